@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"s0counter/pkg/app"
+	"s0counter/pkg/app/config"
+	"time"
+)
+
+// adminFlags contains the command line flags of the admin commands
+type adminFlags struct {
+	Command    string          // show, offset, exchange
+	Meter      string          // name of the meter
+	Offset     float64         // new reading offset of command offset
+	OldReading float64         // final reading of the replaced meter of command exchange
+	NewReading float64         // start reading of the new meter of command exchange
+	Note       string          // note of command exchange
+	Set        map[string]bool // flags set on the command line
+}
+
+// runAdmin sends the admin command to the admin endpoint of the running s0counter and prints the calibration of the meter.
+func runAdmin(cfg *config.Config, f adminFlags) error {
+	if !cfg.Webserver.Webservices["admin"] {
+		return fmt.Errorf("webservice admin isn't enabled")
+	}
+	if f.Meter == "" {
+		return fmt.Errorf("admin command needs a meter")
+	}
+
+	u, err := url.Parse(cfg.Webserver.URL)
+	if err != nil {
+		return err
+	}
+
+	// the webserver listens on all interfaces, the local s0counter is reached by the loopback interface
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	u.Host = net.JoinHostPort(host, port)
+	u.Path = "/admin/" + url.PathEscape(f.Meter)
+	u.RawQuery = ""
+
+	var method string
+	var body interface{}
+	switch f.Command {
+	case "show":
+		method = http.MethodGet
+	case "offset":
+		if !f.Set["offset"] {
+			return fmt.Errorf("admin command offset needs -offset")
+		}
+		method, u.Path = http.MethodPut, u.Path+"/offset"
+		body = app.AdminOffset{Offset: &f.Offset}
+	case "exchange":
+		if !f.Set["oldreading"] || !f.Set["newreading"] {
+			return fmt.Errorf("admin command exchange needs -oldreading and -newreading")
+		}
+		method, u.Path = http.MethodPost, u.Path+"/exchange"
+		body = app.AdminExchange{OldReading: &f.OldReading, NewReading: &f.NewReading, Note: f.Note}
+	default:
+		return fmt.Errorf("unknown admin command %q", f.Command)
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.Webserver.AdminToken)
+
+	res, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %s", res.Status, b)
+	}
+
+	var out bytes.Buffer
+	if err = json.Indent(&out, b, "", "  "); err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
@@ -28,8 +28,19 @@ func main() {
 	flag.BoolVar(&cfg.Flag.Version, "version", false, "print version and exit")
 	flag.StringVar(&cfg.Flag.Debug, "debug", "", "enable debug information (standard | trace | debug)")
 	flag.StringVar(&cfg.Flag.ConfigFile, "config", defaultConfigFile, "config file")
+
+	var admin adminFlags
+	flag.StringVar(&admin.Command, "admin", "", "send an admin command to the running s0counter and exit (show | offset | exchange)")
+	flag.StringVar(&admin.Meter, "meter", "", "meter of the admin command")
+	flag.Float64Var(&admin.Offset, "offset", 0, "reading offset of admin command offset")
+	flag.Float64Var(&admin.OldReading, "oldreading", 0, "final reading of the replaced meter of admin command exchange")
+	flag.Float64Var(&admin.NewReading, "newreading", 0, "start reading of the new meter of admin command exchange")
+	flag.StringVar(&admin.Note, "note", "", "note of admin command exchange")
 	flag.Parse()
 
+	admin.Set = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { admin.Set[f.Name] = true })
+
 	if cfg.Flag.Version {
 		fmt.Println(app.Version())
 		exitCode = 0
@@ -42,6 +53,16 @@ func main() {
 		return
 	}
 
+	if admin.Command != "" {
+		if err := runAdmin(cfg, admin); err != nil {
+			fmt.Println(err)
+			exitCode = 1
+			return
+		}
+		exitCode = 0
+		return
+	}
+
 	debug.SetDebug(cfg.Debug.File, cfg.Debug.Flag)
 	defer func() {
 		debug.InfoLog.Printf("closing debug file %s", cfg.Debug.FileString)
@@ -5,8 +5,13 @@ go 1.15
 require (
 	github.com/eclipse/paho.mqtt.golang v1.3.4
 	github.com/gofiber/fiber/v2 v2.12.0
+	github.com/gofiber/websocket/v2 v2.0.5
+	github.com/valyala/fasthttp v1.26.0
 	github.com/warthog618/gpio v1.0.0
+	github.com/warthog618/gpiod v0.8.2
 	github.com/womat/debug v0.0.3
 	github.com/womat/tools v0.0.2
+	go.etcd.io/bbolt v1.3.6
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/yaml.v2 v2.2.4
 )
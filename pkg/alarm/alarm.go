@@ -0,0 +1,83 @@
+// Package alarm provides threshold alarms, the condition of a rule is evaluated against a value
+// and the alarm is raised or cleared with hysteresis and hold times.
+package alarm
+
+import "time"
+
+// Conditions of a rule, the value is compared with the threshold.
+var Conditions = map[string]bool{">": true, ">=": true, "<": true, "<=": true}
+
+// Rule defines the condition of an alarm.
+type Rule struct {
+	Condition  string        // >, >=, <, <=
+	Threshold  float64       // the alarm is raised, if the condition value <condition> threshold is true
+	Hysteresis float64       // the alarm is cleared, if the value passes the threshold by the hysteresis in the opposite direction
+	Hold       time.Duration // the condition must be true for hold, before the alarm is raised
+	ClearHold  time.Duration // the clear condition must be true for clear hold, before the alarm is cleared
+}
+
+// State is the state of an alarm.
+type State struct {
+	Active    bool      `yaml:"active"`    // true, if the alarm is raised
+	TimeStamp time.Time `yaml:"timestamp"` // time of the last raise or clear, it's zero if the alarm has never been raised
+	Value     float64   `yaml:"value"`     // last evaluated value
+
+	// Pending is the start of a pending raise or clear, which waits for the hold time, it's zero if nothing is pending.
+	// It isn't persisted, the condition hasn't been observed while the application was stopped.
+	Pending time.Time `yaml:"-"`
+}
+
+// Update evaluates the rule with value v at time t and updates the state.
+// Update returns true, if the alarm has been raised or cleared.
+func (r Rule) Update(s *State, v float64, t time.Time) bool {
+	s.Value = v
+
+	change, hold := r.raise(v), r.Hold
+	if s.Active {
+		change, hold = r.clear(v), r.ClearHold
+	}
+
+	if !change {
+		s.Pending = time.Time{}
+		return false
+	}
+
+	if s.Pending.IsZero() {
+		s.Pending = t
+	}
+	if t.Sub(s.Pending) < hold {
+		return false
+	}
+
+	s.Active, s.TimeStamp, s.Pending = !s.Active, t, time.Time{}
+	return true
+}
+
+// raise returns true, if the condition of the rule is true.
+func (r Rule) raise(v float64) bool {
+	switch r.Condition {
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	default:
+		return v > r.Threshold
+	}
+}
+
+// clear returns true, if the value has passed the threshold and the hysteresis in the opposite direction of the condition,
+// e.g. condition > 100 with hysteresis 10 is cleared at 90 or below.
+func (r Rule) clear(v float64) bool {
+	switch r.Condition {
+	case ">=":
+		return v < r.Threshold-r.Hysteresis
+	case "<":
+		return v >= r.Threshold+r.Hysteresis
+	case "<=":
+		return v > r.Threshold+r.Hysteresis
+	default:
+		return v <= r.Threshold-r.Hysteresis
+	}
+}
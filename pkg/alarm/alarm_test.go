@@ -0,0 +1,77 @@
+package alarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	type step struct {
+		v      float64
+		after  int // seconds since the first step
+		active bool
+	}
+
+	for _, tc := range []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{">", Rule{Condition: ">", Threshold: 100}, []step{
+			{100, 0, false}, {101, 1, true}, {100, 2, false},
+		}},
+		{">=", Rule{Condition: ">=", Threshold: 100}, []step{
+			{99, 0, false}, {100, 1, true}, {99.9, 2, false},
+		}},
+		{"<", Rule{Condition: "<", Threshold: 10}, []step{
+			{10, 0, false}, {9, 1, true}, {10, 2, false},
+		}},
+		{"<=", Rule{Condition: "<=", Threshold: 10}, []step{
+			{11, 0, false}, {10, 1, true}, {10.1, 2, false},
+		}},
+		// the alarm is cleared, if the value passes the threshold by the hysteresis
+		{"> hysteresis", Rule{Condition: ">", Threshold: 100, Hysteresis: 10}, []step{
+			{101, 0, true}, {95, 1, true}, {90.1, 2, true}, {90, 3, false}, {100, 4, false}, {100.1, 5, true},
+		}},
+		{">= hysteresis", Rule{Condition: ">=", Threshold: 100, Hysteresis: 10}, []step{
+			{100, 0, true}, {90, 1, true}, {89.9, 2, false},
+		}},
+		{"< hysteresis", Rule{Condition: "<", Threshold: 10, Hysteresis: 5}, []step{
+			{9, 0, true}, {14.9, 1, true}, {15, 2, false}, {10, 3, false},
+		}},
+		{"<= hysteresis", Rule{Condition: "<=", Threshold: 10, Hysteresis: 5}, []step{
+			{10, 0, true}, {15, 1, true}, {15.1, 2, false},
+		}},
+		// the condition must be true for the hold time, an interruption restarts the hold time
+		{"hold", Rule{Condition: ">", Threshold: 100, Hold: 10 * time.Second}, []step{
+			{101, 0, false}, {101, 9, false}, {99, 10, false}, {101, 11, false}, {101, 20, false}, {101, 21, true}, {99, 22, false},
+		}},
+		{"clear hold", Rule{Condition: ">", Threshold: 100, Hysteresis: 10, ClearHold: 5 * time.Second}, []step{
+			{101, 0, true}, {80, 1, true}, {95, 3, true}, {80, 4, true}, {80, 8, true}, {80, 9, false},
+		}},
+		{"hold and clear hold", Rule{Condition: "<", Threshold: 10, Hold: 2 * time.Second, ClearHold: 2 * time.Second}, []step{
+			{5, 0, false}, {5, 2, true}, {20, 3, true}, {20, 5, false}, {5, 6, false},
+		}},
+	} {
+		var s State
+		t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+		for i, st := range tc.steps {
+			ts := t0.Add(time.Duration(st.after) * time.Second)
+
+			was := s.Active
+			changed := tc.rule.Update(&s, st.v, ts)
+			if s.Active != st.active {
+				t.Errorf("%v step %v (value %v): got active %v, want %v", tc.name, i, st.v, s.Active, st.active)
+			}
+			if changed != (was != s.Active) {
+				t.Errorf("%v step %v (value %v): got changed %v, want %v", tc.name, i, st.v, changed, was != s.Active)
+			}
+			if changed && !s.TimeStamp.Equal(ts) {
+				t.Errorf("%v step %v: got time stamp %v, want %v", tc.name, i, s.TimeStamp, ts)
+			}
+			if s.Value != st.v {
+				t.Errorf("%v step %v: got value %v, want %v", tc.name, i, s.Value, st.v)
+			}
+		}
+	}
+}
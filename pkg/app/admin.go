@@ -0,0 +1,170 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"s0counter/pkg/meter"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/womat/debug"
+)
+
+// AdminMeter is the response of the admin endpoints, it contains the calibration of the meter.
+type AdminMeter struct {
+	Meter       string           // name of the meter
+	Counter     float64          // current counter including the reading offset, eg kWh, l, m³
+	UnitCounter string           // unit of current meter counter e.g. kWh, l, m³
+	Offset      float64          // reading offset, eg kWh, l, m³
+	Exchanges   []meter.Exchange // history of the meter exchanges
+}
+
+// AdminOffset is the request body of PUT /admin/<meter>/offset.
+type AdminOffset struct {
+	Offset *float64 // new reading offset, eg kWh, l, m³
+}
+
+// AdminExchange is the request body of POST /admin/<meter>/exchange.
+type AdminExchange struct {
+	OldReading *float64 // final reading of the replaced meter
+	NewReading *float64 // start reading of the new meter
+	Note       string   // optional note, e.g. the serial number of the new meter
+}
+
+// adminAuth checks the bearer token of the admin requests.
+// The config ensures an admin token, an empty token is refused anyway, so the admin requests are never open.
+func (app *App) adminAuth() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		token := app.config.Webserver.AdminToken
+		if token == "" || subtle.ConstantTimeCompare([]byte(ctx.Get(fiber.HeaderAuthorization)), []byte("Bearer "+token)) != 1 {
+			return fiber.NewError(http.StatusUnauthorized, "invalid admin token")
+		}
+		return ctx.Next()
+	}
+}
+
+// adminMeter returns the meter of the request, virtual meters have no calibration.
+func (app *App) adminMeter(ctx *fiber.Ctx) (string, *meter.Meter, error) {
+	name := ctx.Params("meter")
+	m, ok := app.meters[name]
+	if !ok {
+		return "", nil, fiber.NewError(http.StatusNotFound, fmt.Sprintf("unknown meter %q", name))
+	}
+	if m.Config.Expr != nil {
+		return "", nil, fiber.NewError(http.StatusBadRequest, fmt.Sprintf("virtual meter %q has no calibration", name))
+	}
+	return name, m, nil
+}
+
+// adminResponse returns the calibration of the meter, the meter must be locked by the caller.
+func adminResponse(name string, m *meter.Meter) AdminMeter {
+	return AdminMeter{
+		Meter:       name,
+		Counter:     calcCounter(m),
+		UnitCounter: m.Config.UnitCounter,
+		Offset:      readingOffset(m),
+		Exchanges:   append([]meter.Exchange{}, m.Exchanges...),
+	}
+}
+
+// HandleAdminMeter returns the counter, the reading offset and the exchange history of a meter.
+func (app *App) HandleAdminMeter() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request admin meter")
+
+		name, m, err := app.adminMeter(ctx)
+		if err != nil {
+			return err
+		}
+
+		m.RLock()
+		defer m.RUnlock()
+		return ctx.JSON(adminResponse(name, m))
+	}
+}
+
+// HandleAdminOffset sets the reading offset of a meter, e.g. {"Offset": 12345.6}.
+// The counter is changed by the difference of the offsets, the consumption of the periods is kept.
+func (app *App) HandleAdminOffset() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request admin offset")
+
+		name, m, err := app.adminMeter(ctx)
+		if err != nil {
+			return err
+		}
+
+		var req AdminOffset
+		if err = json.Unmarshal(ctx.Body(), &req); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		if req.Offset == nil {
+			return fiber.NewError(http.StatusBadRequest, "offset is missing")
+		}
+
+		m.Lock()
+		debug.InfoLog.Printf("set offset of meter %v from %v to %v", name, readingOffset(m), *req.Offset)
+		m.Offset = req.Offset
+		resp := adminResponse(name, m)
+		m.Unlock()
+
+		return app.adminSave(ctx, name, resp)
+	}
+}
+
+// HandleAdminExchange records a meter exchange, e.g. {"OldReading": 12345.6, "NewReading": 0.5, "Note": "SN 4711"}.
+// The counter continues with the start reading of the new meter, the consumption of the periods is kept.
+func (app *App) HandleAdminExchange() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request admin exchange")
+
+		name, m, err := app.adminMeter(ctx)
+		if err != nil {
+			return err
+		}
+
+		var req AdminExchange
+		if err = json.Unmarshal(ctx.Body(), &req); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		if req.OldReading == nil {
+			return fiber.NewError(http.StatusBadRequest, "oldreading is missing")
+		}
+		if req.NewReading == nil {
+			return fiber.NewError(http.StatusBadRequest, "newreading is missing")
+		}
+
+		m.Lock()
+		e := meter.Exchange{
+			TimeStamp:  time.Now(),
+			OldReading: *req.OldReading,
+			NewReading: *req.NewReading,
+			Counter:    calcCounter(m),
+			Note:       req.Note,
+		}
+		// the deviation shows missed or additional pulses of the replaced meter since the last calibration
+		e.Deviation = toFixed(e.OldReading-e.Counter, counterPrecision)
+		// the offset is chosen, so the counter of the current ticks is the start reading of the new meter
+		offset := e.NewReading - tickCounter(m)
+		m.Offset = &offset
+		m.Exchanges = append(m.Exchanges, e)
+		resp := adminResponse(name, m)
+		m.Unlock()
+
+		debug.InfoLog.Printf("exchange meter %v: counter %v, old reading %v (deviation %v), new reading %v", name, e.Counter, e.OldReading, e.Deviation, e.NewReading)
+		return app.adminSave(ctx, name, resp)
+	}
+}
+
+// adminSave saves the changed calibration, publishes the new counter and returns the calibration of the meter.
+func (app *App) adminSave(ctx *fiber.Ctx, name string, resp AdminMeter) error {
+	if err := app.saveMeasurements(); err != nil {
+		debug.ErrorLog.Printf("can't save measurements: %v", err)
+		return fiber.NewError(http.StatusInternalServerError, err.Error())
+	}
+
+	go app.sendMQTT(name)
+	return ctx.JSON(resp)
+}
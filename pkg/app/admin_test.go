@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newAdminApp returns an app with the admin webservice and a meter with 12000 ticks (12 kWh).
+func newAdminApp(t *testing.T, token string) *App {
+	c := config.NewConfig()
+	c.DataFile = filepath.Join(t.TempDir(), "measurement.yaml")
+	c.Location = time.UTC
+	c.Webserver.Webservices = map[string]bool{"admin": true}
+	c.Webserver.AdminToken = token
+
+	app := &App{config: c, web: fiber.New(), mqtt: mqtt.New(), meters: map[string]*meter.Meter{
+		"power": {Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh"}, S0: meter.S0{Tick: 12000}},
+	}}
+	app.initDefaultRoutes()
+	return app
+}
+
+func TestAdminAuth(t *testing.T) {
+	for _, token := range []string{"", "secret"} {
+		app := newAdminApp(t, token)
+
+		for _, auth := range []string{"", "Bearer ", "Bearer wrong"} {
+			req := httptest.NewRequest("GET", "/admin/power", nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			resp, err := app.web.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("token %q, authorization %q: status %v, want %v", token, auth, resp.StatusCode, http.StatusUnauthorized)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/admin/power", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := newAdminApp(t, "secret").web.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token: status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestAdminExchange(t *testing.T) {
+	app := newAdminApp(t, "secret")
+
+	req := httptest.NewRequest("POST", "/admin/power/exchange", strings.NewReader(`{"OldReading": 12.5, "NewReading": 0.5, "Note": "SN 4711"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := app.web.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var a AdminMeter
+	if err = json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Counter != 0.5 || a.Offset != -11.5 {
+		t.Errorf("counter %v, offset %v, want 0.5, -11.5", a.Counter, a.Offset)
+	}
+	if len(a.Exchanges) != 1 {
+		t.Fatalf("%v exchanges, want 1", len(a.Exchanges))
+	}
+	if e := a.Exchanges[0]; e.OldReading != 12.5 || e.Counter != 12 || e.Deviation != 0.5 || e.NewReading != 0.5 || e.Note != "SN 4711" {
+		t.Errorf("unexpected exchange %+v", e)
+	}
+}
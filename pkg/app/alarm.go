@@ -0,0 +1,324 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"s0counter/pkg/alarm"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/womat/debug"
+	"github.com/womat/tools"
+	"gopkg.in/yaml.v2"
+)
+
+// states of the alarm events
+const (
+	alarmRaised  = "raised"
+	alarmCleared = "cleared"
+)
+
+// AlarmEvent is published on a raise or a clear of an alarm to topic <alarm topic>/<rule> and kept in the alarm history.
+type AlarmEvent struct {
+	Rule      string    `yaml:"rule"`      // name of the alarm rule
+	Meter     string    `yaml:"meter"`     // name of the meter
+	State     string    `yaml:"state"`     // raised or cleared
+	Severity  string    `yaml:"severity"`  // info, warning, critical
+	Message   string    `yaml:"message"`   // configured message of the rule
+	Value     float64   `yaml:"value"`     // value of the meter, which raised or cleared the alarm
+	Threshold float64   `yaml:"threshold"` // threshold of the rule
+	TimeStamp time.Time `yaml:"timestamp"` // time of the raise or the clear
+}
+
+// alarmStatus is the current state of an alarm rule of the alarms webservice.
+type alarmStatus struct {
+	Rule      string     // name of the alarm rule
+	Meter     string     // name of the meter
+	Value     string     // evaluated value of the meter, e.g. gauge, today, sincepulse
+	Condition string     // condition of the rule, e.g. >
+	Threshold float64    // threshold of the rule
+	Severity  string     // info, warning, critical
+	Message   string     // configured message of the rule
+	Active    bool       // true, if the alarm is raised
+	Since     time.Time  // time of the last raise or clear, it's zero if the alarm has never been raised
+	Pending   *time.Time `json:",omitempty"` // start of a raise or a clear, which waits for the hold time
+	Current   float64    // last evaluated value
+}
+
+// alarms holds the states of the alarm rules and the alarm history.
+type alarms struct {
+	sync.Mutex
+	// started is the start of the evaluation, it replaces the time of the last pulse of meters without pulses
+	started time.Time
+	states  map[string]*alarm.State
+	history []AlarmEvent
+}
+
+// savedAlarms is the content of the alarm file.
+type savedAlarms struct {
+	States  map[string]alarm.State `yaml:"states"`
+	History []AlarmEvent           `yaml:"history"`
+}
+
+// initAlarms initializes the states of the alarm rules and loads the saved states and the history, if an alarm file is defined.
+func (app *App) initAlarms() error {
+	a := &alarms{started: app.now(), states: map[string]*alarm.State{}}
+	for name := range app.config.Alarm.Rules {
+		a.states[name] = &alarm.State{}
+	}
+	app.alarms = a
+
+	fileName := app.config.Alarm.File
+	if fileName == "" || !tools.FileExists(fileName) {
+		return nil
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var s savedAlarms
+	if err = yaml.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	// the states of removed rules are dropped, the history is kept
+	for name, state := range s.States {
+		if _, ok := a.states[name]; ok {
+			*a.states[name] = state
+		}
+	}
+	a.history = s.History
+	if n := len(a.history) - app.config.Alarm.HistorySize; n > 0 {
+		a.history = a.history[n:]
+	}
+	return nil
+}
+
+// evaluateAlarms evaluates the alarm rules at time t, the raised and cleared alarms are published and added to the history.
+func (app *App) evaluateAlarms(t time.Time) {
+	if len(app.config.Alarm.Rules) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(app.config.Alarm.Rules))
+	for name := range app.config.Alarm.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var events []AlarmEvent
+	for _, name := range names {
+		c := app.config.Alarm.Rules[name]
+
+		m := app.meters[c.Meter]
+		m.RLock()
+		v := app.alarmValue(m, c.Value, t)
+		m.RUnlock()
+
+		app.alarms.Lock()
+		s := app.alarms.states[name]
+		if alarmRule(c).Update(s, v, t) {
+			e := AlarmEvent{Rule: name, Meter: c.Meter, State: alarmCleared, Severity: c.Severity, Message: c.Message, Value: v, Threshold: c.Threshold, TimeStamp: t}
+			if s.Active {
+				e.State = alarmRaised
+			}
+			events = append(events, e)
+
+			app.alarms.history = append(app.alarms.history, e)
+			if n := len(app.alarms.history) - app.config.Alarm.HistorySize; n > 0 {
+				app.alarms.history = app.alarms.history[n:]
+			}
+		}
+		app.alarms.Unlock()
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	for _, e := range events {
+		debug.InfoLog.Printf("alarm %v of meter %v %v: value %v, threshold %v", e.Rule, e.Meter, e.State, e.Value, e.Threshold)
+		app.sendAlarm(e)
+		app.notify(WebhookEvent{Event: webhookAlarm, TimeStamp: e.TimeStamp, Meter: e.Meter, Data: e})
+	}
+
+	if err := app.saveAlarms(); err != nil {
+		debug.ErrorLog.Printf("can't save alarms: %v", err)
+	}
+}
+
+// alarmRule returns the rule of the alarm configuration.
+func alarmRule(c config.AlarmRuleConfig) alarm.Rule {
+	return alarm.Rule{Condition: c.Condition, Threshold: c.Threshold, Hysteresis: c.Hysteresis, Hold: c.Hold, ClearHold: c.ClearHold}
+}
+
+// alarmValue returns the value of the meter, which is evaluated by an alarm rule.
+//  gauge, counter: current gauge and counter
+//  thishour, lasthour, today, ... : consumption of the periods
+//  sincepulse: seconds since the last pulse, of both registers of a bidirectional meter
+//  demand, projecteddemand: average power of the current demand window and the projected average power
+// The meter must be locked by the caller.
+func (app *App) alarmValue(m *meter.Meter, value string, t time.Time) float64 {
+	switch value {
+	case "counter":
+		return calcCounter(m)
+	case "sincepulse":
+		last := m.S0.TimeStamp
+		if m.Export.TimeStamp.After(last) {
+			last = m.Export.TimeStamp
+		}
+		if last.IsZero() {
+			last = app.alarms.started
+		}
+		return t.Sub(last).Seconds()
+	case "demand", "projecteddemand":
+		d := app.calcDemand(m, t)
+		if value == "projecteddemand" && d.Projected != nil {
+			return *d.Projected
+		}
+		return d.Current
+	}
+
+	c := app.calcConsumption(m, t)
+	switch value {
+	case "thishour":
+		return c.ThisHour
+	case "lasthour":
+		return c.LastHour
+	case "today":
+		return c.Today
+	case "yesterday":
+		return c.Yesterday
+	case "thisweek":
+		return c.ThisWeek
+	case "lastweek":
+		return c.LastWeek
+	case "thismonth":
+		return c.ThisMonth
+	case "lastmonth":
+		return c.LastMonth
+	case "thisyear":
+		return c.ThisYear
+	case "lastyear":
+		return c.LastYear
+	default:
+		return calcGauge(m, t)
+	}
+}
+
+// sendAlarm publishes the alarm event retained to topic <alarm topic>/<rule>, so the topic contains the current state of the alarm.
+func (app *App) sendAlarm(e AlarmEvent) {
+	if app.config.Alarm.Topic == "" {
+		return
+	}
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		debug.ErrorLog.Printf("sendAlarm marshal: %v", err)
+		return
+	}
+
+	app.mqtt.C <- mqtt.Message{
+		Qos:       1,
+		Retained:  true,
+		Topic:     app.config.Alarm.Topic + "/" + e.Rule,
+		Payload:   b,
+		TimeStamp: e.TimeStamp,
+	}
+}
+
+// saveAlarms saves the states of the alarm rules and the history, if an alarm file is defined.
+func (app *App) saveAlarms() error {
+	if app.config.Alarm.File == "" {
+		return nil
+	}
+
+	app.alarms.Lock()
+	s := savedAlarms{States: map[string]alarm.State{}, History: append([]AlarmEvent(nil), app.alarms.history...)}
+	for name, state := range app.alarms.states {
+		s.States[name] = *state
+	}
+	app.alarms.Unlock()
+
+	data, err := yaml.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(app.config.Alarm.File, data, 0o600)
+}
+
+// HandleAlarms returns the current state of the alarm rules.
+// Parameters:
+//  active >> true: only the raised alarms
+func (app *App) HandleAlarms() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request alarms")
+
+		app.alarms.Lock()
+		defer app.alarms.Unlock()
+
+		list := []alarmStatus{}
+		for name, c := range app.config.Alarm.Rules {
+			s := app.alarms.states[name]
+			if ctx.Query("active") == "true" && !s.Active {
+				continue
+			}
+
+			a := alarmStatus{Rule: name, Meter: c.Meter, Value: c.Value, Condition: c.Condition, Threshold: c.Threshold,
+				Severity: c.Severity, Message: c.Message, Active: s.Active, Since: s.TimeStamp, Current: s.Value}
+			if !s.Pending.IsZero() {
+				p := s.Pending
+				a.Pending = &p
+			}
+			list = append(list, a)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Rule < list[j].Rule })
+
+		return ctx.JSON(list)
+	}
+}
+
+// HandleAlarmHistory returns the raised and cleared alarms, the latest event first.
+// Parameters:
+//  rule >> only the events of the rule
+//  limit >> maximum number of events (default: all events of the history)
+func (app *App) HandleAlarmHistory() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request alarm history")
+
+		limit := app.config.Alarm.HistorySize
+		if l := ctx.Query("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("invalid limit %q", l))
+			}
+		}
+
+		rule := ctx.Query("rule")
+		if _, ok := app.config.Alarm.Rules[rule]; rule != "" && !ok {
+			return fiber.NewError(http.StatusNotFound, fmt.Sprintf("unknown alarm rule %q", rule))
+		}
+
+		app.alarms.Lock()
+		defer app.alarms.Unlock()
+
+		events := []AlarmEvent{}
+		for i := len(app.alarms.history) - 1; i >= 0 && len(events) < limit; i-- {
+			if e := app.alarms.history[i]; rule == "" || e.Rule == rule {
+				events = append(events, e)
+			}
+		}
+
+		return ctx.JSON(events)
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"path/filepath"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"testing"
+	"time"
+)
+
+func TestAlarmPersistence(t *testing.T) {
+	c := config.NewConfig()
+	c.Location = time.UTC
+	c.Alarm = config.AlarmConfig{
+		File:        filepath.Join(t.TempDir(), "alarms.yaml"),
+		HistorySize: 2,
+		Rules: map[string]config.AlarmRuleConfig{
+			"high": {Meter: "power", Value: "counter", Condition: ">", Threshold: 5, Hysteresis: 1},
+			"low":  {Meter: "power", Value: "counter", Condition: "<", Threshold: 1},
+		},
+	}
+	m := &meter.Meter{
+		Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh"},
+		S0:     meter.S0{Tariffs: map[string]uint64{}, Periods: map[period.Period]period.Register{}},
+		Export: meter.S0{Periods: map[period.Period]period.Register{}},
+	}
+	app := &App{config: c, mqtt: mqtt.New(), meters: map[string]*meter.Meter{"power": m}}
+	if err := app.initAlarms(); err != nil {
+		t.Fatal(err)
+	}
+
+	// low is raised, cleared, high is raised, cleared and raised again, the history keeps the last 2 events
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i, ticks := range []uint64{500, 3000, 6000, 4500, 3900, 7000} {
+		m.S0.Tick = ticks
+		app.evaluateAlarms(t0.Add(time.Duration(i) * time.Minute))
+	}
+
+	want := []AlarmEvent{
+		{Rule: "high", Meter: "power", State: alarmCleared, Value: 3.9, Threshold: 5, TimeStamp: t0.Add(4 * time.Minute)},
+		{Rule: "high", Meter: "power", State: alarmRaised, Value: 7, Threshold: 5, TimeStamp: t0.Add(5 * time.Minute)},
+	}
+	checkHistory := func(name string, got []AlarmEvent, want []AlarmEvent) {
+		t.Helper()
+
+		if len(got) != len(want) {
+			t.Fatalf("%v: got %v events, want %v", name, len(got), len(want))
+		}
+		for i := range want {
+			if g := got[i]; g.Rule != want[i].Rule || g.State != want[i].State || g.Value != want[i].Value || !g.TimeStamp.Equal(want[i].TimeStamp) {
+				t.Errorf("%v event %v: got %v %v %v at %v, want %v %v %v at %v", name, i,
+					g.Rule, g.State, g.Value, g.TimeStamp, want[i].Rule, want[i].State, want[i].Value, want[i].TimeStamp)
+			}
+		}
+	}
+	checkHistory("evaluated", app.alarms.history, want)
+
+	// the states and the history are loaded from the alarm file, the states of removed rules are dropped
+	c.Alarm.HistorySize = 1
+	delete(c.Alarm.Rules, "low")
+	c.Alarm.Rules["new"] = config.AlarmRuleConfig{Meter: "power", Value: "counter", Condition: ">", Threshold: 100}
+	if err := app.initAlarms(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := app.alarms.states["high"]; !s.Active || !s.TimeStamp.Equal(t0.Add(5*time.Minute)) || s.Value != 7 {
+		t.Errorf("loaded state of high: got active %v since %v value %v, want active since %v value 7", s.Active, s.TimeStamp, s.Value, t0.Add(5*time.Minute))
+	}
+	if s := app.alarms.states["new"]; s == nil || s.Active {
+		t.Errorf("state of the new rule: got %v, want inactive", s)
+	}
+	if _, ok := app.alarms.states["low"]; ok {
+		t.Error("state of the removed rule is loaded")
+	}
+	// the history is trimmed to the new history size
+	checkHistory("loaded", app.alarms.history, want[1:])
+}
@@ -3,9 +3,14 @@ package app
 import (
 	"net/url"
 	"s0counter/pkg/app/config"
+	"s0counter/pkg/history"
 	"s0counter/pkg/meter"
 	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"s0counter/pkg/pulselog"
 	"s0counter/pkg/raspberry"
+	"s0counter/pkg/tariff"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/womat/debug"
@@ -34,6 +39,27 @@ type App struct {
 	// gpio is the handler to the rpi gpio memory
 	gpio raspberry.GPIO
 
+	// tariff is the time-of-use tariff schedule, it's nil if no tariff is defined
+	tariff *tariff.Schedule
+
+	// history is the time-series store of the counters, it's nil if no history file is defined
+	history *history.Store
+
+	// recorder records all accepted pulses, if a record file is defined
+	recorder *pulselog.Writer
+
+	// sparkplug is the sparkplug b edge node, it's nil if sparkplug is disabled
+	sparkplug *sparkplugNode
+
+	// alarms holds the states of the alarm rules and the alarm history
+	alarms *alarms
+
+	// webhooks posts the events to the webhook endpoints
+	webhooks *webhooks
+
+	// events distributes the pulse and interval events to the stream clients
+	events *eventHub
+
 	// restart signals application restart
 	restart chan struct{}
 	// shutdown signals application shutdown
@@ -48,7 +74,7 @@ func New(config *config.Config) (*App, error) {
 		return &App{}, err
 	}
 
-	gpio, err := raspberry.Open()
+	gpio, err := openGPIO(config)
 	if err != nil {
 		debug.ErrorLog.Printf("can't open gpio: %v", err)
 		return &App{}, err
@@ -62,6 +88,7 @@ func New(config *config.Config) (*App, error) {
 		web:    fiber.New(),
 		meters: meter.New(),
 		mqtt:   mqtt.New(),
+		events: newEventHub(),
 
 		restart:  make(chan struct{}),
 		shutdown: make(chan struct{}),
@@ -75,14 +102,29 @@ func (app *App) Run() error {
 	}
 
 	for _, m := range app.meters {
-		go testPinEmu(m.LineHandler)
+		// virtual meters have no pin
+		if m.LineHandler != nil {
+			go testPinEmu(m.LineHandler)
+		}
+		if m.ExportLineHandler != nil {
+			go testPinEmu(m.ExportLineHandler)
+		}
+	}
+
+	// start drivers which generate the edges by themselves, e.g. the replay of a recording
+	if s, ok := app.gpio.(raspberry.Starter); ok {
+		s.Start()
 	}
 
 	go app.mqtt.Service()
 	go app.calcGauge()
 	go app.backupMeasurements()
+	if app.history != nil {
+		go app.recordHistory()
+	}
 	go app.runWebServer()
 
+	app.notify(WebhookEvent{Event: webhookStartup, TimeStamp: time.Now(), Data: VersionRecord{Module: MODULE, Version: VERSION}})
 	return nil
 }
 
@@ -91,38 +133,93 @@ func (app *App) init() (err error) {
 	for meterName, meterConfig := range app.config.Meter {
 		app.meters[meterName] = &meter.Meter{
 			Config: meterConfig,
+			S0:     meter.S0{Tariffs: map[string]uint64{}, Periods: map[period.Period]period.Register{}},
+			Export: meter.S0{Periods: map[period.Period]period.Register{}},
 		}
 	}
 
+	// the operands of the virtual meters are linked after all meters are created
+	for _, m := range app.meters {
+		if m.Config.Expr == nil {
+			continue
+		}
+
+		m.Operands = map[string]*meter.Meter{}
+		for _, v := range m.Config.Expr.Variables() {
+			m.Operands[v] = app.meters[v]
+		}
+	}
+
+	if app.tariff, err = newTariffSchedule(app.config.Tariff); err != nil {
+		debug.ErrorLog.Printf("can't load tariff schedule: %v", err)
+		return err
+	}
+
 	if err = app.loadMeasurements(); err != nil {
 		debug.ErrorLog.Printf("can't open data file: %v", err)
 		return err
 	}
 
+	if err = app.initAlarms(); err != nil {
+		debug.ErrorLog.Printf("can't open alarm file: %v", err)
+		return err
+	}
+
+	app.initWebhooks()
+
+	if app.history, err = openHistory(app.config.History, app.config.Location); err != nil {
+		debug.ErrorLog.Printf("can't open history file: %v", err)
+		return err
+	}
+
+	if app.config.RecordFile != "" {
+		if app.recorder, err = pulselog.Create(app.config.RecordFile); err != nil {
+			debug.ErrorLog.Printf("can't open record file: %v", err)
+			return err
+		}
+	}
+
 	for name, meterConfig := range app.config.Meter {
-		if m, ok := app.meters[name]; ok {
-			if m.LineHandler, err = app.gpio.NewPin(meterConfig.Gpio); err != nil {
-				debug.ErrorLog.Printf("can't open pin: %v", err)
-				return
+		if m, ok := app.meters[name]; ok && meterConfig.Expr == nil {
+			if m.LineHandler, err = app.openPin(name, false, meterConfig.Gpio, meterConfig); err != nil {
+				return err
 			}
 
-			app.meters[name] = m
-			app.meters[name].LineHandler.Input()
-			app.meters[name].LineHandler.PullUp()
-			app.meters[name].LineHandler.SetBounceTime(meterConfig.BounceTime)
-			// call handler when pin changes from low to high.
-			if err = app.meters[name].LineHandler.Watch(raspberry.EdgeFalling, app.handler); err != nil {
-				debug.ErrorLog.Printf("can't open watcher: %v", err)
-				return err
+			if meterConfig.Bidirectional() {
+				if m.ExportLineHandler, err = app.openPin(name, true, meterConfig.ExportGpio, meterConfig); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
-	if err = app.mqtt.Connect(app.config.MQTT.Connection); err != nil {
+	if app.config.MQTT.Queue.File != "" {
+		if err = app.mqtt.OpenQueue(app.config.MQTT.Queue.File, app.config.MQTT.Queue.Size, app.config.MQTT.Queue.DropPolicy == "oldest"); err != nil {
+			debug.ErrorLog.Printf("can't open mqtt queue: %v", err)
+			return err
+		}
+	}
+
+	app.initStatus()
+	if err = app.initDiscovery(); err != nil {
+		debug.ErrorLog.Printf("can't init mqtt discovery: %v", err)
+		return err
+	}
+	if err = app.initCommands(); err != nil {
+		debug.ErrorLog.Printf("can't init mqtt commands: %v", err)
+		return err
+	}
+
+	if err = app.mqtt.Connect(app.mqttOptions(app.config.MQTT.ClientID)); err != nil {
 		debug.ErrorLog.Printf("can't open mqtt broker %v", err)
 		return err
 	}
 
+	if err = app.initSparkplug(); err != nil {
+		debug.ErrorLog.Printf("can't open sparkplug connection %v", err)
+		return err
+	}
+
 	// initRoutes and initDefaultRoutes should be always called last because it may access things like app.api
 	// which must be initialized before in initAPI()
 	app.initDefaultRoutes()
@@ -130,6 +227,52 @@ func (app *App) init() (err error) {
 	return nil
 }
 
+// openPin opens the S0 input gpio of the meter and calls the handler when pin changes according to the configured edge,
+// export selects the export input of a bidirectional meter.
+// If the meter defines a chip, the gpio is opened on this chip, otherwise on the default chip.
+func (app *App) openPin(name string, export bool, gpio int, c config.MeterConfig) (p raspberry.Pin, err error) {
+	if cg, ok := app.gpio.(raspberry.ChipGPIO); ok && c.Chip != "" {
+		p, err = cg.NewChipPin(c.Chip, gpio)
+	} else {
+		p, err = app.gpio.NewPin(gpio)
+	}
+	if err != nil {
+		debug.ErrorLog.Printf("can't open pin: %v", err)
+		return nil, err
+	}
+
+	p.Input()
+	switch c.Pull {
+	case "up":
+		p.PullUp()
+	case "down":
+		p.PullDown()
+	default:
+		p.PullNone()
+	}
+	p.SetBounceTime(c.BounceTime)
+	if err = p.Watch(raspberry.Edge(c.Edge), func(p raspberry.Pin) { app.handler(name, export, p) }); err != nil {
+		debug.ErrorLog.Printf("can't open watcher: %v", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// mqttOptions returns the connection properties of the mqtt broker with the client id.
+func (app *App) mqttOptions(clientID string) mqtt.Options {
+	return mqtt.Options{
+		Broker:             app.config.MQTT.Connection,
+		Username:           app.config.MQTT.Username,
+		Password:           app.config.MQTT.Password,
+		ClientID:           clientID,
+		CAFile:             app.config.MQTT.CAFile,
+		CertFile:           app.config.MQTT.CertFile,
+		KeyFile:            app.config.MQTT.KeyFile,
+		InsecureSkipVerify: app.config.MQTT.InsecureSkipVerify,
+		KeepAlive:          app.config.MQTT.KeepAlive,
+	}
+}
+
 // Restart returns the read only restart channel.
 // Restart is used to be able to react on application restart. (see cmd/main.go)
 func (app *App) Restart() <-chan struct{} {
@@ -146,10 +289,26 @@ func (app *App) Close() error {
 	// app.chip.Close() unwatch all pins and release the gpio memory!
 	_ = app.gpio.Close()
 
+	app.closeWebhooks()
+
+	if app.sparkplug != nil {
+		app.sparkplugDeath()
+		_ = app.sparkplug.handler.Disconnect()
+	}
+
 	if app.mqtt != nil {
+		app.publishOffline()
 		_ = app.mqtt.Disconnect()
 	}
 
+	if app.recorder != nil {
+		_ = app.recorder.Close()
+	}
+
+	if app.history != nil {
+		_ = app.history.Close()
+	}
+
 	_ = app.saveMeasurements()
 	return nil
 }
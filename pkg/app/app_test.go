@@ -0,0 +1,165 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/internal/mqtttest"
+	"testing"
+	"time"
+)
+
+// loadTestConfig writes the yaml configuration to a temporary file and loads it.
+func loadTestConfig(t *testing.T, yaml string) *config.Config {
+	t.Helper()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	yaml = fmt.Sprintf("datafile: %v\n", filepath.Join(dir, "measurement.yaml")) + yaml
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.NewConfig()
+	c.Flag.ConfigFile = file
+	if err := c.LoadConfig(); err != nil {
+		t.Fatalf("can't load config: %v", err)
+	}
+	return c
+}
+
+// TestSimulatedMeter drives a simulated S0 input with 10 pulses per second (36 kW at 1000 imp/kWh)
+// and checks the counter and the gauge of the currentdata webservice and the mqtt messages.
+func TestSimulatedMeter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test of the simulated meter")
+	}
+
+	broker := mqtttest.Start(t, nil)
+	c := loadTestConfig(t, fmt.Sprintf(`
+datacollectioninterval: 1
+backupinterval: 3600
+timezone: UTC
+debug:
+  file: stderr
+  flag: standard
+gpio:
+  driver: sim
+webserver:
+  url: http://127.0.0.1:0
+  webservices:
+    currentdata: true
+mqtt:
+  connection: %v
+  statustopic: test/status
+meter:
+  power:
+    gpio: 17
+    counterconstant: 1000
+    unitcounter: kWh
+    scalefactor: 1000
+    unitgauge: W
+    mqtttopic: test/power
+    simulation:
+      profile: constant
+      rate: 36000
+      pulsewidth: 30
+`, broker.URL))
+
+	app, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err = app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	// the data collection interval publishes the values every second, the gauge needs a full interval
+	var r MQTTRecord
+	waitFor(t, 10*time.Second, "a mqtt message of meter power with a gauge of 36000 W", func() bool {
+		msg, ok := broker.Last("test/power")
+		if !ok {
+			return false
+		}
+		if err := json.Unmarshal(msg.Payload, &r); err != nil {
+			t.Fatalf("invalid mqtt payload %q: %v", msg.Payload, err)
+		}
+		return math.Abs(r.Gauge-36000) <= 36000*0.15
+	})
+	if r.Counter <= 0 || r.UnitCounter != "kWh" || r.UnitGauge != "W" {
+		t.Errorf("mqtt record: counter %v %v, gauge unit %v", r.Counter, r.UnitCounter, r.UnitGauge)
+	}
+	if _, ok := broker.Last("test/status"); !ok {
+		t.Error("no mqtt status message")
+	}
+
+	// the gauge of the last pulse interval decays after the last pulse, it's checked while the pulses are generated
+	if got := currentData(t, app)["power"].Gauge; math.Abs(got-36000) > 36000*0.15 {
+		t.Errorf("currentdata gauge %v W, want 36000 W", got)
+	}
+
+	// stop the simulation and wait until the counter doesn't change anymore
+	_ = app.gpio.Close()
+	elapsed := time.Since(start)
+	m := app.meters["power"]
+	last := uint64(math.MaxUint64)
+	var ticks uint64
+	waitFor(t, 5*time.Second, "the last pulse", func() bool {
+		m.RLock()
+		ticks = m.S0.Tick
+		m.RUnlock()
+
+		stable := ticks == last
+		last = ticks
+		time.Sleep(50 * time.Millisecond)
+		return stable
+	})
+
+	// 10 pulses per second while the simulation was running
+	if want := elapsed.Seconds() * 10; math.Abs(float64(ticks)-want) > want*0.2+3 {
+		t.Errorf("%v ticks, want about %.0f", ticks, want)
+	}
+	counter := currentData(t, app)["power"].Counter
+	if want := float64(ticks) / 1000; counter != want {
+		t.Errorf("currentdata counter %v kWh, want %v kWh", counter, want)
+	}
+	if r.Counter > counter {
+		t.Errorf("mqtt counter %v is greater than the final counter %v", r.Counter, counter)
+	}
+}
+
+// waitFor polls the condition until it's true, the test fails if the condition isn't true within the timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// currentData returns the counters and gauges of the currentdata webservice.
+func currentData(t *testing.T, app *App) map[string]resp {
+	t.Helper()
+
+	res, err := app.web.Test(httptest.NewRequest("GET", "/currentdata", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var data map[string]resp
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
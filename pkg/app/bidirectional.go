@@ -0,0 +1,39 @@
+package app
+
+import (
+	"s0counter/pkg/meter"
+	"time"
+)
+
+// direction contains the counter, the gauge and the consumption of one direction of a bidirectional meter.
+type direction struct {
+	Counter     float64     // counter of the direction, eg kWh
+	Gauge       float64     // gauge of the direction, e.g. kW
+	Consumption consumption // consumption of the direction in the current and the previous periods
+}
+
+// calcImport returns the import counter, gauge and consumption of a bidirectional meter at time t, nil for other meters.
+// The meter must be locked by the caller.
+func (app *App) calcImport(m *meter.Meter, t time.Time) *direction {
+	if !m.Config.Bidirectional() {
+		return nil
+	}
+	return &direction{
+		Counter:     float64(m.S0.Tick) / m.Config.CounterConstant,
+		Gauge:       toFixed(registerGauge(m.S0, m.Config, t), m.Config.Precision),
+		Consumption: app.registerConsumption(m.S0, nil, m.Config.CounterConstant, t),
+	}
+}
+
+// calcExport returns the export counter, gauge and consumption of a bidirectional meter at time t, nil for other meters.
+// The meter must be locked by the caller.
+func (app *App) calcExport(m *meter.Meter, t time.Time) *direction {
+	if !m.Config.Bidirectional() {
+		return nil
+	}
+	return &direction{
+		Counter:     float64(m.Export.Tick) / m.Config.CounterConstant,
+		Gauge:       toFixed(registerGauge(m.Export, m.Config, t), m.Config.Precision),
+		Consumption: app.registerConsumption(m.Export, nil, m.Config.CounterConstant, t),
+	}
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/womat/debug"
+)
+
+// supported commands of the command topic <prefix>/<meter>/set
+const (
+	cmdSetCounter   = "setcounter"
+	cmdResetPeriods = "resetperiods"
+	cmdPublish      = "publish"
+)
+
+// Command is the payload of the command topic <prefix>/<meter>/set, e.g.
+//  {"ID":"42","Command":"setcounter","Value":12345.6,"Token":"secret"}
+//  {"ID":"43","Command":"resetperiods","Period":"day","Token":"secret"}
+//  {"ID":"44","Command":"publish","Token":"secret"}
+type Command struct {
+	ID      string  // id of the command, it's returned in the response
+	Command string  // setcounter, resetperiods, publish
+	Value   float64 // new counter of setcounter, eg kWh, l, m³
+	Period  string  // period of resetperiods (hour, day, week, month, year), if it's empty, all periods are reset
+	Token   string  `json:",omitempty"` // shared secret of the commands, it's removed before the command is logged
+}
+
+// errInvalidToken is returned for a command without a valid token
+var errInvalidToken = errors.New("invalid token")
+
+// CommandResponse is the acknowledgment of a command, it's sent to topic <prefix>/<meter>/response
+type CommandResponse struct {
+	ID        string    // id of the command
+	Meter     string    // name of the meter
+	Command   string    // executed command
+	TimeStamp time.Time // time of the execution
+	Success   bool      // true, if the command has been executed
+	Error     string    `json:",omitempty"` // reason, if the command has failed
+}
+
+// auditRecord is an entry of the audit log, one json record per line.
+type auditRecord struct {
+	TimeStamp time.Time
+	Meter     string
+	Command   Command
+	Old       float64 `json:",omitempty"` // counter before setcounter
+	Success   bool
+	Error     string `json:",omitempty"`
+}
+
+// auditLock serializes the writes to the audit log
+var auditLock sync.Mutex
+
+// initCommands subscribes the command topics of all meters.
+func (app *App) initCommands() error {
+	if !app.config.MQTT.Commands.Enabled {
+		return nil
+	}
+
+	return app.mqtt.Subscribe(app.config.MQTT.Commands.Prefix+"/+/set", 1, app.handleCommand)
+}
+
+// handleCommand executes a received command and publishes the response.
+func (app *App) handleCommand(msg mqtt.Message) {
+	t := strings.Split(msg.Topic, "/")
+	if len(t) < 2 {
+		return
+	}
+	name := t[len(t)-2]
+
+	var cmd Command
+	a := auditRecord{TimeStamp: time.Now(), Meter: name}
+	err := json.Unmarshal(msg.Payload, &cmd)
+	if err == nil {
+		token := app.config.MQTT.Commands.Token
+		valid := token != "" && subtle.ConstantTimeCompare([]byte(cmd.Token), []byte(token)) == 1
+		cmd.Token = ""
+		a.Command = cmd
+
+		if valid {
+			a.Old, err = app.executeCommand(name, cmd)
+		} else {
+			err = errInvalidToken
+		}
+	}
+
+	a.Success = err == nil
+	if err != nil {
+		a.Error = err.Error()
+	}
+	app.audit(a)
+
+	r := CommandResponse{ID: cmd.ID, Meter: name, Command: cmd.Command, TimeStamp: a.TimeStamp, Success: a.Success, Error: a.Error}
+	b, err := json.Marshal(r)
+	if err != nil {
+		debug.ErrorLog.Printf("command response marshal: %v", err)
+		return
+	}
+
+	go func() {
+		app.mqtt.C <- mqtt.Message{
+			Qos:       1,
+			Retained:  false,
+			Topic:     app.config.MQTT.Commands.Prefix + "/" + name + "/response",
+			Payload:   b,
+			TimeStamp: r.TimeStamp,
+		}
+	}()
+}
+
+// executeCommand executes the command on the meter, setcounter returns the counter before the command.
+func (app *App) executeCommand(name string, cmd Command) (old float64, err error) {
+	m, ok := app.meters[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown meter %q", name)
+	}
+
+	if m.Config.Expr != nil && (cmd.Command == cmdSetCounter || cmd.Command == cmdResetPeriods) {
+		return 0, fmt.Errorf("command %q isn't supported by the virtual meter %q", cmd.Command, name)
+	}
+
+	switch cmd.Command {
+	case cmdSetCounter:
+		m.Lock()
+		old = calcCounter(m)
+		// the reading offset is kept, the net counter of a bidirectional meter is set by the import register
+		offset := readingOffset(m)
+		t := math.Round((cmd.Value-offset)*m.Config.CounterConstant) + float64(m.Export.Tick)
+		if t < 0 {
+			err = fmt.Errorf("counter must not be less than %v", offset-float64(m.Export.Tick)/m.Config.CounterConstant)
+			m.Unlock()
+			return 0, err
+		}
+		ticks := uint64(t)
+
+		// the consumption of the current periods is kept, the start of the periods is shifted by the difference
+		for p, r := range m.S0.Periods {
+			r.StartTicks = shiftTicks(r.StartTicks, ticks, m.S0.Tick)
+			m.S0.Periods[p] = r
+		}
+		m.S0.Demand.Shift(ticks, m.S0.Tick)
+		m.S0.Gauge.Tick = shiftTicks(m.S0.Gauge.Tick, ticks, m.S0.Tick)
+		if w := app.webhooks; w != nil {
+			w.Lock()
+			if b, ok := w.ticks[name]; ok {
+				b[0] = shiftTicks(b[0], ticks, m.S0.Tick)
+				w.ticks[name] = b
+			}
+			w.Unlock()
+		}
+		m.S0.Tick = ticks
+		m.Unlock()
+
+		if err = app.saveMeasurements(); err != nil {
+			return old, fmt.Errorf("can't save measurements: %w", err)
+		}
+		go app.sendMQTT(name)
+
+	case cmdResetPeriods:
+		periods := period.All
+		if cmd.Period != "" {
+			periods = nil
+			for _, p := range period.All {
+				if string(p) == cmd.Period {
+					periods = []period.Period{p}
+				}
+			}
+			if periods == nil {
+				return 0, fmt.Errorf("unknown period %q", cmd.Period)
+			}
+		}
+
+		m.Lock()
+		for _, p := range periods {
+			// an empty register starts a new period with the current ticks
+			m.S0.Periods[p] = period.Register{}
+			delete(m.Export.Periods, p)
+		}
+		app.updatePeriods(name, m, app.now())
+		m.Unlock()
+
+		if err = app.saveMeasurements(); err != nil {
+			return 0, fmt.Errorf("can't save measurements: %w", err)
+		}
+
+	case cmdPublish:
+		go app.sendMQTT(name)
+
+	default:
+		return 0, fmt.Errorf("unknown command %q", cmd.Command)
+	}
+
+	return old, nil
+}
+
+// shiftTicks shifts the start ticks of a period by the difference between the new and the old ticks.
+func shiftTicks(start, ticks, old uint64) uint64 {
+	if ticks >= old {
+		return start + (ticks - old)
+	}
+	if d := old - ticks; start > d {
+		return start - d
+	}
+	return 0
+}
+
+// audit logs the executed command and appends it to the audit log file.
+func (app *App) audit(a auditRecord) {
+	debug.InfoLog.Printf("command %q of meter %v, success: %v %v", a.Command.Command, a.Meter, a.Success, a.Error)
+
+	f := app.config.MQTT.Commands.AuditFile
+	if f == "" {
+		return
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		debug.ErrorLog.Printf("audit marshal: %v", err)
+		return
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	file, err := os.OpenFile(f, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		debug.ErrorLog.Printf("can't open audit file: %v", err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		debug.ErrorLog.Printf("can't write audit file: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandToken(t *testing.T) {
+	dir := t.TempDir()
+	c := config.NewConfig()
+	c.Location = time.UTC
+	c.DataFile = filepath.Join(dir, "measurement.yaml")
+	c.MQTT.Commands = config.CommandConfig{Enabled: true, Prefix: "s0counter", Token: "secret", AuditFile: filepath.Join(dir, "audit.log")}
+	m := &meter.Meter{
+		Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh"},
+		S0:     meter.S0{Tick: 5000, Tariffs: map[string]uint64{}, Periods: map[period.Period]period.Register{}},
+		Export: meter.S0{Periods: map[period.Period]period.Register{}},
+	}
+	app := &App{config: c, mqtt: mqtt.New(), meters: map[string]*meter.Meter{"power": m}}
+
+	for _, tc := range []struct {
+		token   string
+		success bool
+		ticks   uint64
+	}{
+		{"", false, 5000},
+		{"wrong", false, 5000},
+		{"secret", true, 12000},
+	} {
+		b, _ := json.Marshal(Command{ID: "42", Command: cmdSetCounter, Value: 12, Token: tc.token})
+		app.handleCommand(mqtt.Message{Topic: "s0counter/power/set", Payload: b})
+
+		r := commandResponse(t, app)
+		if r.Success != tc.success {
+			t.Errorf("token %q: success %v, want %v (%v)", tc.token, r.Success, tc.success, r.Error)
+		}
+
+		m.RLock()
+		ticks := m.S0.Tick
+		m.RUnlock()
+		if ticks != tc.ticks {
+			t.Errorf("token %q: %v ticks, want %v", tc.token, ticks, tc.ticks)
+		}
+	}
+
+	// every command is audited, the token isn't logged
+	b, err := os.ReadFile(c.MQTT.Commands.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 3 {
+		t.Errorf("%v audit records, want 3", n)
+	}
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), "wrong") {
+		t.Errorf("audit log contains the token: %s", b)
+	}
+	if !strings.Contains(string(b), errInvalidToken.Error()) {
+		t.Errorf("audit log doesn't contain the rejected commands: %s", b)
+	}
+}
+
+func TestSetCounterShift(t *testing.T) {
+	c := config.NewConfig()
+	c.Location = time.UTC
+	c.DataFile = filepath.Join(t.TempDir(), "measurement.yaml")
+	m := &meter.Meter{
+		Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh"},
+		S0: meter.S0{Tick: 5000, Gauge: meter.GaugeState{Tick: 4000}, Tariffs: map[string]uint64{},
+			Periods: map[period.Period]period.Register{period.Day: {StartTicks: 3000}}},
+		Export: meter.S0{Periods: map[period.Period]period.Register{}},
+	}
+	app := &App{config: c, mqtt: mqtt.New(), meters: map[string]*meter.Meter{"power": m},
+		webhooks: &webhooks{ticks: map[string][2]uint64{"power": {4500, 0}}}}
+
+	// the pulses since the start of the period, the gauge interval and the webhook batch are kept
+	for _, tc := range []struct {
+		value                   float64
+		day, gauge, batch, tick uint64
+	}{
+		{12, 10000, 11000, 11500, 12000},
+		{1, 0, 0, 500, 1000},
+	} {
+		if _, err := app.executeCommand("power", Command{Command: cmdSetCounter, Value: tc.value}); err != nil {
+			t.Fatal(err)
+		}
+
+		m.RLock()
+		day, gauge, tick := m.S0.Periods[period.Day].StartTicks, m.S0.Gauge.Tick, m.S0.Tick
+		m.RUnlock()
+		batch := app.webhooks.ticks["power"][0]
+		if day != tc.day || gauge != tc.gauge || batch != tc.batch || tick != tc.tick {
+			t.Errorf("counter %v: got day %v, gauge %v, batch %v, ticks %v, want %v, %v, %v, %v",
+				tc.value, day, gauge, batch, tick, tc.day, tc.gauge, tc.batch, tc.tick)
+		}
+	}
+}
+
+// commandResponse returns the next command response, other messages are skipped.
+func commandResponse(t *testing.T, app *App) (r CommandResponse) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-app.mqtt.C:
+			if !strings.HasSuffix(msg.Topic, "/response") {
+				continue
+			}
+			if err := json.Unmarshal(msg.Payload, &r); err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no command response")
+			return
+		}
+	}
+}
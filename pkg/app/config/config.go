@@ -1,9 +1,15 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"s0counter/pkg/alarm"
+	"s0counter/pkg/expression"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/womat/debug"
@@ -20,9 +26,17 @@ type Config struct {
 	DataCollectionInterval    time.Duration          `yaml:"-"`
 	DataCollectionIntervalInt int                    `yaml:"datacollectioninterval"`
 	DataFile                  string                 `yaml:"datafile"`
+	RecordFile                string                 `yaml:"recordfile"`
 	BackupInterval            time.Duration          `yaml:"-"`
 	BackupIntervalInt         int                    `yaml:"backupinterval"`
+	TimeZone                  string                 `yaml:"timezone"`
+	Location                  *time.Location         `yaml:"-"`
 	Debug                     DebugConfig            `yaml:"debug"`
+	GPIO                      GPIOConfig             `yaml:"gpio"`
+	Tariff                    TariffConfig           `yaml:"tariff"`
+	History                   HistoryConfig          `yaml:"history"`
+	Alarm                     AlarmConfig            `yaml:"alarm"`
+	Webhook                   WebhookConfig          `yaml:"webhook"`
 	Meter                     map[string]MeterConfig `yaml:"meter"`
 	Webserver                 WebserverConfig        `yaml:"webserver"`
 	MQTT                      MQTTConfig             `yaml:"mqtt"`
@@ -40,11 +54,154 @@ type FlagConfig struct {
 type WebserverConfig struct {
 	URL         string          `yaml:"url"`
 	Webservices map[string]bool `yaml:"webservices"`
+	AdminToken  string          `yaml:"admintoken"`
+}
+
+// GPIOConfig defines the struct of the gpio driver configuration and configuration file
+type GPIOConfig struct {
+	Driver      string  `yaml:"driver"`
+	Chip        string  `yaml:"chip"`
+	ReplayFile  string  `yaml:"replayfile"`
+	ReplaySpeed float64 `yaml:"replayspeed"`
+}
+
+// TariffConfig defines the struct of the time-of-use tariff schedule and configuration file
+type TariffConfig struct {
+	Default       string               `yaml:"default"`
+	HolidayTariff string               `yaml:"holidaytariff"`
+	HolidayFile   string               `yaml:"holidays"`
+	Schedule      []TariffWindowConfig `yaml:"schedule"`
+}
+
+// TariffWindowConfig defines the struct of a time window of the tariff schedule
+type TariffWindowConfig struct {
+	Tariff      string         `yaml:"tariff"`
+	WeekdaysStr []string       `yaml:"weekdays"`
+	Weekdays    []time.Weekday `yaml:"-"`
+	FromStr     string         `yaml:"from"`
+	From        time.Duration  `yaml:"-"`
+	ToStr       string         `yaml:"to"`
+	To          time.Duration  `yaml:"-"`
+}
+
+// HistoryConfig defines the struct of the history store configuration and configuration file
+type HistoryConfig struct {
+	File      string              `yaml:"file"`
+	Rules     []HistoryRuleConfig `yaml:"rules"`
+	MaxPoints int                 `yaml:"maxpoints"`
+}
+
+// HistoryRuleConfig defines the struct of a resolution of the history store
+type HistoryRuleConfig struct {
+	ResolutionInt int           `yaml:"resolution"`
+	Resolution    time.Duration `yaml:"-"`
+	RetentionInt  int           `yaml:"retention"`
+	Retention     time.Duration `yaml:"-"`
+}
+
+// AlarmConfig defines the struct of the alarm rules and the alarm history
+type AlarmConfig struct {
+	Topic       string                     `yaml:"topic"`
+	File        string                     `yaml:"file"`
+	HistorySize int                        `yaml:"historysize"`
+	Rules       map[string]AlarmRuleConfig `yaml:"rules"`
+}
+
+// AlarmRuleConfig defines the struct of an alarm rule, the condition is evaluated against a value of a meter
+type AlarmRuleConfig struct {
+	Meter        string        `yaml:"meter"`
+	Value        string        `yaml:"value"`
+	Condition    string        `yaml:"condition"`
+	Threshold    float64       `yaml:"threshold"`
+	Hysteresis   float64       `yaml:"hysteresis"`
+	HoldInt      int           `yaml:"hold"`
+	Hold         time.Duration `yaml:"-"`
+	ClearHoldInt int           `yaml:"clearhold"`
+	ClearHold    time.Duration `yaml:"-"`
+	Severity     string        `yaml:"severity"`
+	Message      string        `yaml:"message"`
+}
+
+// WebhookConfig defines the struct of the outgoing webhooks
+type WebhookConfig struct {
+	BatchIntervalInt int                              `yaml:"batchinterval"`
+	BatchInterval    time.Duration                    `yaml:"-"`
+	StallTimeoutInt  int                              `yaml:"stalltimeout"`
+	StallTimeout     time.Duration                    `yaml:"-"`
+	Endpoints        map[string]WebhookEndpointConfig `yaml:"endpoints"`
+}
+
+// WebhookEndpointConfig defines the struct of a webhook endpoint
+type WebhookEndpointConfig struct {
+	URL           string             `yaml:"url"`
+	Events        []string           `yaml:"events"`
+	EventFilter   map[string]bool    `yaml:"-"`
+	Meters        []string           `yaml:"meters"`
+	MeterFilter   map[string]bool    `yaml:"-"`
+	Secret        string             `yaml:"secret"`
+	ContentType   string             `yaml:"contenttype"`
+	Headers       map[string]string  `yaml:"headers"`
+	TemplateStr   string             `yaml:"template"`
+	Template      *template.Template `yaml:"-"`
+	TimeoutInt    int                `yaml:"timeout"`
+	Timeout       time.Duration      `yaml:"-"`
+	RetriesPtr    *int               `yaml:"retries"`
+	Retries       int                `yaml:"-"`
+	BackoffInt    int                `yaml:"backoff"`
+	Backoff       time.Duration      `yaml:"-"`
+	MaxBackoffInt int                `yaml:"maxbackoff"`
+	MaxBackoff    time.Duration      `yaml:"-"`
 }
 
 // MQTTConfig defines the struct of the mqtt client configuration and configuration file
 type MQTTConfig struct {
-	Connection string `yaml:"connection"`
+	Connection         string          `yaml:"connection"`
+	Username           string          `yaml:"username"`
+	Password           string          `yaml:"password"`
+	PasswordFile       string          `yaml:"passwordfile"`
+	ClientID           string          `yaml:"clientid"`
+	CAFile             string          `yaml:"cafile"`
+	CertFile           string          `yaml:"certfile"`
+	KeyFile            string          `yaml:"keyfile"`
+	InsecureSkipVerify bool            `yaml:"insecureskipverify"`
+	KeepAliveInt       int             `yaml:"keepalive"`
+	KeepAlive          time.Duration   `yaml:"-"`
+	StatusTopic        string          `yaml:"statustopic"`
+	Discovery          DiscoveryConfig `yaml:"discovery"`
+	Queue              QueueConfig     `yaml:"queue"`
+	Commands           CommandConfig   `yaml:"commands"`
+	Sparkplug          SparkplugConfig `yaml:"sparkplug"`
+}
+
+// SparkplugConfig defines the struct of the sparkplug b output
+type SparkplugConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	GroupID    string `yaml:"groupid"`
+	EdgeNodeID string `yaml:"edgenodeid"`
+	ClientID   string `yaml:"clientid"`
+	File       string `yaml:"file"`
+}
+
+// CommandConfig defines the struct of the mqtt command topics
+type CommandConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Prefix    string `yaml:"prefix"`
+	Token     string `yaml:"token"`
+	AuditFile string `yaml:"auditfile"`
+}
+
+// QueueConfig defines the struct of the persistent queue of the mqtt messages, which couldn't be published
+type QueueConfig struct {
+	File       string `yaml:"file"`
+	Size       int    `yaml:"size"`
+	DropPolicy string `yaml:"droppolicy"`
+}
+
+// DiscoveryConfig defines the struct of the home assistant mqtt discovery
+type DiscoveryConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Prefix  string `yaml:"prefix"`
+	NodeID  string `yaml:"nodeid"`
 }
 
 // DebugConfig defines the struct of the debug configuration and configuration file
@@ -57,15 +214,98 @@ type DebugConfig struct {
 
 // MeterConfig defines the struct of the meter configuration and configuration file
 type MeterConfig struct {
-	Gpio            int           `yaml:"gpio"`
-	BounceTimeInt   int           `yaml:"bouncetime"`
-	BounceTime      time.Duration `yaml:"-"`
-	CounterConstant float64       `yaml:"counterconstant"`
-	UnitCounter     string        `yaml:"unitcounter"`
-	ScaleFactor     float64       `yaml:"scalefactor"`
-	Precision       int           `yaml:"precision "`
-	UnitGauge       string        `yaml:"unitgauge"`
-	MqttTopic       string        `yaml:"mqtttopic"`
+	Type             string                 `yaml:"type"`
+	Chip             string                 `yaml:"chip"`
+	Gpio             int                    `yaml:"gpio"`
+	ExportGpio       int                    `yaml:"exportgpio"`
+	BounceTimeInt    int                    `yaml:"bouncetime"`
+	BounceTime       time.Duration          `yaml:"-"`
+	CounterConstant  float64                `yaml:"counterconstant"`
+	UnitCounter      string                 `yaml:"unitcounter"`
+	Offset           float64                `yaml:"offset"`
+	ScaleFactor      float64                `yaml:"scalefactor"`
+	Precision        int                    `yaml:"precision "`
+	UnitGauge        string                 `yaml:"unitgauge"`
+	MqttTopic        string                 `yaml:"mqtttopic"`
+	DeviceClass      string                 `yaml:"deviceclass"`
+	Expression       string                 `yaml:"expression"`
+	Expr             *expression.Expression `yaml:"-"`
+	Gauge            GaugeConfig            `yaml:"gauge"`
+	Demand           DemandConfig           `yaml:"demand"`
+	Payload          PayloadConfig          `yaml:"payload"`
+	Publish          PublishConfig          `yaml:"publish"`
+	Edge             string                 `yaml:"edge"`
+	Pull             string                 `yaml:"pull"`
+	ActiveLevel      string                 `yaml:"activelevel"`
+	CountMode        string                 `yaml:"countmode"`
+	Filter           FilterConfig           `yaml:"filter"`
+	Tariff           bool                   `yaml:"tariff"`
+	Simulation       SimulationConfig       `yaml:"simulation"`
+	ExportSimulation SimulationConfig       `yaml:"exportsimulation"`
+}
+
+// FilterConfig defines the struct of the pulse plausibility filter of a meter
+type FilterConfig struct {
+	MinPulseWidthInt int           `yaml:"minpulsewidth"`
+	MinPulseWidth    time.Duration `yaml:"-"`
+	MaxPulseWidthInt int           `yaml:"maxpulsewidth"`
+	MaxPulseWidth    time.Duration `yaml:"-"`
+	MinIntervalInt   int           `yaml:"mininterval"`
+	MinInterval      time.Duration `yaml:"-"`
+}
+
+// GaugeConfig defines the struct of the gauge algorithm of a meter
+type GaugeConfig struct {
+	Algorithm      string        `yaml:"algorithm"`
+	WindowPulses   int           `yaml:"windowpulses"`
+	WindowInt      int           `yaml:"window"`
+	Window         time.Duration `yaml:"-"`
+	Alpha          float64       `yaml:"alpha"`
+	ZeroTimeoutInt int           `yaml:"zerotimeout"`
+	ZeroTimeout    time.Duration `yaml:"-"`
+}
+
+// DemandConfig defines the struct of the demand register of a meter
+type DemandConfig struct {
+	WindowInt  int           `yaml:"window"`
+	Window     time.Duration `yaml:"-"`
+	Mode       string        `yaml:"mode"`
+	Projection bool          `yaml:"projection"`
+}
+
+// PayloadConfig defines the struct of the mqtt payload format of a meter
+type PayloadConfig struct {
+	Format      string             `yaml:"format"`
+	Fields      map[string]string  `yaml:"fields"`
+	TemplateStr string             `yaml:"template"`
+	Template    *template.Template `yaml:"-"`
+	Qos         byte               `yaml:"qos"`
+	RetainPtr   *bool              `yaml:"retain"`
+	Retained    bool               `yaml:"-"`
+}
+
+// PublishConfig defines the struct of the mqtt publish policy of a meter
+type PublishConfig struct {
+	Policy           string        `yaml:"policy"`
+	IntervalInt      int           `yaml:"interval"`
+	Interval         time.Duration `yaml:"-"`
+	MinIntervalInt   int           `yaml:"mininterval"`
+	MinInterval      time.Duration `yaml:"-"`
+	HeartbeatInt     int           `yaml:"heartbeat"`
+	Heartbeat        time.Duration `yaml:"-"`
+	Deadband         float64       `yaml:"deadband"`
+	DeadbandRelative float64       `yaml:"deadbandrelative"`
+}
+
+// SimulationConfig defines the struct of the pulse simulation of a meter (gpio driver sim)
+type SimulationConfig struct {
+	Profile       string          `yaml:"profile"`
+	Rate          float64         `yaml:"rate"`
+	SequenceInt   []int           `yaml:"sequence"`
+	Sequence      []time.Duration `yaml:"-"`
+	PulseWidthInt int             `yaml:"pulsewidth"`
+	PulseWidth    time.Duration   `yaml:"-"`
+	Bounces       int             `yaml:"bounces"`
 }
 
 func NewConfig() *Config {
@@ -76,10 +316,26 @@ func NewConfig() *Config {
 		DataFile:                  "/opt/womat/data/measurement.yaml",
 		BackupInterval:            0,
 		BackupIntervalInt:         0,
+		TimeZone:                  "Local",
 		Debug: DebugConfig{
 			FileString: "stderr",
 			FlagString: "standard",
 		},
+		GPIO: GPIOConfig{
+			Driver:      "gpiomem",
+			Chip:        "gpiochip0",
+			ReplaySpeed: 1,
+		},
+		History: HistoryConfig{
+			MaxPoints: 10000,
+		},
+		Alarm: AlarmConfig{
+			Topic:       "s0counter/alarm",
+			HistorySize: 100,
+		},
+		Webhook: WebhookConfig{
+			BatchIntervalInt: 60,
+		},
 		Meter: map[string]MeterConfig{},
 		Webserver: WebserverConfig{
 			URL: "http://0.0.0.0:4000",
@@ -88,7 +344,27 @@ func NewConfig() *Config {
 				"currentdata": true,
 			},
 		},
-		MQTT: MQTTConfig{Connection: "tcp:127.0.0.1883"},
+		MQTT: MQTTConfig{
+			Connection:   "tcp:127.0.0.1883",
+			KeepAliveInt: 30,
+			StatusTopic:  "s0counter/status",
+			Discovery: DiscoveryConfig{
+				Prefix: "homeassistant",
+				NodeID: "s0counter",
+			},
+			Queue: QueueConfig{
+				Size:       10000,
+				DropPolicy: "oldest",
+			},
+			Commands: CommandConfig{
+				Prefix: "s0counter",
+			},
+			Sparkplug: SparkplugConfig{
+				GroupID:    "s0counter",
+				EdgeNodeID: "s0counter",
+				File:       "/opt/womat/data/sparkplug.yaml",
+			},
+		},
 	}
 }
 
@@ -104,14 +380,803 @@ func (c *Config) LoadConfig() error {
 		return fmt.Errorf("unable to open debug file %q: %w", c.Debug, err)
 	}
 
+	switch c.GPIO.Driver {
+	case "gpiomem", "gpiod", "sim":
+	case "replay":
+		if c.GPIO.ReplayFile == "" {
+			return fmt.Errorf("gpio driver replay needs a replayfile")
+		}
+		if c.GPIO.ReplayFile == c.RecordFile {
+			return fmt.Errorf("replayfile and recordfile must be different")
+		}
+		if c.GPIO.ReplaySpeed <= 0 {
+			return fmt.Errorf("replayspeed must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("unsupported gpio driver %q", c.GPIO.Driver)
+	}
+
 	c.DataCollectionInterval = time.Duration(c.DataCollectionIntervalInt) * time.Second
 	c.BackupInterval = time.Duration(c.BackupIntervalInt) * time.Second
 
+	var err error
+	if c.Location, err = time.LoadLocation(c.TimeZone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", c.TimeZone, err)
+	}
+
+	// the admin requests change the calibration of the meters, they must not be open
+	if c.Webserver.Webservices["admin"] && c.Webserver.AdminToken == "" {
+		return fmt.Errorf("webservice admin needs an admintoken")
+	}
+
+	if err := c.Tariff.init(); err != nil {
+		return err
+	}
+
+	if err := c.History.init(); err != nil {
+		return err
+	}
+
+	if err := c.MQTT.init(); err != nil {
+		return err
+	}
+
+	if c.MQTT.Discovery.Enabled && (c.MQTT.Discovery.Prefix == "" || c.MQTT.Discovery.NodeID == "" || c.MQTT.StatusTopic == "") {
+		return fmt.Errorf("mqtt discovery needs a prefix, a nodeid and a statustopic")
+	}
+
 	for name, meter := range c.Meter {
+		if c.MQTT.Sparkplug.Enabled && !validSparkplugID(name) {
+			return fmt.Errorf("meter %q: name isn't a valid sparkplug device id", name)
+		}
+
+		if meter.Tariff && c.Tariff.Default == "" {
+			return fmt.Errorf("meter %q: tariff needs a default tariff", name)
+		}
+
+		if meter.Chip != "" && c.GPIO.Driver != "gpiod" && c.GPIO.Driver != "replay" {
+			return fmt.Errorf("meter %q: chip needs gpio driver gpiod or replay", name)
+		}
+
 		meter.BounceTime = time.Duration(meter.BounceTimeInt) * time.Millisecond
+
+		if err := meter.setDeviceClass(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if err := meter.Gauge.init(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if err := meter.Demand.init(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if err := meter.Publish.init(c.DataCollectionInterval); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if err := meter.Payload.init(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		// a queued message is published delayed, so the payload must contain the time of the values
+		if c.MQTT.Queue.File != "" && meter.MqttTopic != "" && !meter.Payload.Retained && !meter.Payload.hasTimeStamp() {
+			return fmt.Errorf("meter %q: payload of not retained messages needs the field TimeStamp, if the mqtt queue is enabled", name)
+		}
+
+		if err := meter.setInput(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if err := meter.setType(); err != nil {
+			return fmt.Errorf("meter %q: %w", name, err)
+		}
+
+		if c.GPIO.Driver == "sim" && meter.Expression == "" {
+			if err := meter.Simulation.init(); err != nil {
+				return fmt.Errorf("meter %q: %w", name, err)
+			}
+		}
+
+		if c.GPIO.Driver == "sim" && meter.Bidirectional() {
+			if err := meter.ExportSimulation.init(); err != nil {
+				return fmt.Errorf("meter %q: export %w", name, err)
+			}
+		}
+
 		c.Meter[name] = meter
 	}
 
+	if err := c.checkGpios(); err != nil {
+		return err
+	}
+
+	if err := c.initVirtualMeters(); err != nil {
+		return err
+	}
+
+	if err := c.Alarm.init(c.Meter); err != nil {
+		return err
+	}
+
+	return c.Webhook.init(c.Meter)
+}
+
+// init validates the tariff schedule and converts the weekdays and times.
+func (t *TariffConfig) init() error {
+	weekdays := map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+
+	if (len(t.Schedule) > 0 || t.HolidayTariff != "") && t.Default == "" {
+		return fmt.Errorf("tariff schedule needs a default tariff")
+	}
+
+	for i := range t.Schedule {
+		w := &t.Schedule[i]
+		if w.Tariff == "" {
+			return fmt.Errorf("tariff schedule %v: tariff is missing", i+1)
+		}
+
+		w.Weekdays = nil
+		for _, d := range w.WeekdaysStr {
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("tariff schedule %v: invalid weekday %q", i+1, d)
+			}
+			w.Weekdays = append(w.Weekdays, wd)
+		}
+
+		// a window without times lasts the whole day
+		if w.FromStr == "" {
+			w.FromStr = "00:00"
+		}
+		if w.ToStr == "" {
+			w.ToStr = "24:00"
+		}
+
+		var err error
+		if w.From, err = parseTimeOfDay(w.FromStr); err != nil {
+			return fmt.Errorf("tariff schedule %v: %w", i+1, err)
+		}
+		if w.To, err = parseTimeOfDay(w.ToStr); err != nil {
+			return fmt.Errorf("tariff schedule %v: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// init validates the rules of the history store and converts the durations.
+// If no rules are defined, minutes are kept for 7 days, hours for a year and days forever.
+func (h *HistoryConfig) init() error {
+	if h.File == "" {
+		return nil
+	}
+	if h.MaxPoints < 0 {
+		return fmt.Errorf("history maxpoints must not be negative")
+	}
+
+	if len(h.Rules) == 0 {
+		h.Rules = []HistoryRuleConfig{
+			{ResolutionInt: 60, RetentionInt: 7},
+			{ResolutionInt: 3600, RetentionInt: 365},
+			{ResolutionInt: 86400, RetentionInt: 0},
+		}
+	}
+
+	for i := range h.Rules {
+		r := &h.Rules[i]
+		if r.ResolutionInt <= 0 || r.RetentionInt < 0 {
+			return fmt.Errorf("history rule %v: resolution must be greater than 0, retention must not be negative", i+1)
+		}
+
+		r.Resolution = time.Duration(r.ResolutionInt) * time.Second
+		r.Retention = time.Duration(r.RetentionInt) * 24 * time.Hour
+	}
+
+	return nil
+}
+
+// parseTimeOfDay converts the time of day in format hh:mm to the duration since midnight, 24:00 is the end of the day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// setType validates the type of the meter, the default is a meter with one S0 input.
+// A bidirectional meter counts the import pulses on gpio and the export pulses on exportgpio.
+func (m *MeterConfig) setType() error {
+	switch m.Type {
+	case "":
+		m.Type = "s0"
+	case "s0":
+	case "bidirectional":
+		if m.Expression != "" {
+			return fmt.Errorf("a virtual meter can't be bidirectional")
+		}
+		if m.ExportGpio == m.Gpio {
+			return fmt.Errorf("exportgpio must differ from gpio")
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", m.Type)
+	}
+	return nil
+}
+
+// Bidirectional returns true, if the meter counts import and export pulses on two inputs.
+func (m MeterConfig) Bidirectional() bool {
+	return m.Type == "bidirectional"
+}
+
+// setInput validates the input configuration of the meter and sets the defaults.
+// The default is an open collector output with pull up resistor, each falling edge is counted.
+func (m *MeterConfig) setInput() error {
+	switch m.Edge {
+	case "":
+		m.Edge = "falling"
+	case "rising", "falling", "both":
+	default:
+		return fmt.Errorf("unsupported edge %q", m.Edge)
+	}
+
+	switch m.Pull {
+	case "":
+		m.Pull = "up"
+	case "up", "down", "none":
+	default:
+		return fmt.Errorf("unsupported pull %q", m.Pull)
+	}
+
+	switch m.ActiveLevel {
+	case "":
+		// an output pulls the level against the pull resistor
+		m.ActiveLevel = "low"
+		if m.Pull == "down" {
+			m.ActiveLevel = "high"
+		}
+	case "low", "high":
+	default:
+		return fmt.Errorf("unsupported activelevel %q", m.ActiveLevel)
+	}
+
+	switch m.CountMode {
+	case "":
+		m.CountMode = "edge"
+	case "edge":
+	case "pulse":
+		if m.Edge != "both" {
+			return fmt.Errorf("countmode pulse needs edge both")
+		}
+	default:
+		return fmt.Errorf("unsupported countmode %q", m.CountMode)
+	}
+
+	f := &m.Filter
+	if f.MinPulseWidthInt < 0 || f.MaxPulseWidthInt < 0 || f.MinIntervalInt < 0 {
+		return fmt.Errorf("filter values must not be negative")
+	}
+	if (f.MinPulseWidthInt > 0 || f.MaxPulseWidthInt > 0) && m.CountMode != "pulse" {
+		return fmt.Errorf("filter of pulse width needs countmode pulse")
+	}
+	if f.MaxPulseWidthInt > 0 && f.MaxPulseWidthInt < f.MinPulseWidthInt {
+		return fmt.Errorf("filter maxpulsewidth must be greater than minpulsewidth")
+	}
+
+	f.MinPulseWidth = time.Duration(f.MinPulseWidthInt) * time.Millisecond
+	f.MaxPulseWidth = time.Duration(f.MaxPulseWidthInt) * time.Millisecond
+	f.MinInterval = time.Duration(f.MinIntervalInt) * time.Millisecond
+	return nil
+}
+
+// init reads the password file, validates the queue, the commands and sparkplug and converts the keep alive interval.
+func (m *MQTTConfig) init() error {
+	if m.PasswordFile != "" {
+		if m.Password != "" {
+			return fmt.Errorf("mqtt password and passwordfile must not be defined both")
+		}
+
+		b, err := os.ReadFile(m.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("can't read mqtt passwordfile: %w", err)
+		}
+		m.Password = strings.TrimSpace(string(b))
+	}
+
+	if (m.CertFile == "") != (m.KeyFile == "") {
+		return fmt.Errorf("mqtt client certificate needs a certfile and a keyfile")
+	}
+
+	switch m.Queue.DropPolicy {
+	case "oldest", "newest":
+	default:
+		return fmt.Errorf("unsupported mqtt queue droppolicy %q", m.Queue.DropPolicy)
+	}
+	if m.Queue.Size <= 0 {
+		return fmt.Errorf("mqtt queue size must be greater than 0")
+	}
+
+	if m.Commands.Enabled && m.Commands.Prefix == "" {
+		return fmt.Errorf("mqtt commands need a prefix")
+	}
+	if m.Commands.Enabled && m.Commands.Token == "" {
+		return fmt.Errorf("mqtt commands need a token")
+	}
+
+	if s := m.Sparkplug; s.Enabled && (!validSparkplugID(s.GroupID) || !validSparkplugID(s.EdgeNodeID)) {
+		return fmt.Errorf("invalid sparkplug groupid %q or edgenodeid %q", s.GroupID, s.EdgeNodeID)
+	}
+
+	if m.KeepAliveInt < 0 {
+		return fmt.Errorf("mqtt keepalive must not be negative")
+	}
+	m.KeepAlive = time.Duration(m.KeepAliveInt) * time.Second
+	return nil
+}
+
+// init validates the publish policy and converts the durations, the default policy is interval,
+// the default interval is the data collection interval.
+func (p *PublishConfig) init(dataCollectionInterval time.Duration) error {
+	switch p.Policy {
+	case "":
+		p.Policy = "interval"
+	case "interval", "pulse", "counter", "gauge":
+	default:
+		return fmt.Errorf("unsupported publish policy %q", p.Policy)
+	}
+
+	if p.IntervalInt < 0 || p.MinIntervalInt < 0 || p.HeartbeatInt < 0 || p.Deadband < 0 || p.DeadbandRelative < 0 {
+		return fmt.Errorf("publish values must not be negative")
+	}
+
+	p.Interval = time.Duration(p.IntervalInt) * time.Second
+	if p.Interval == 0 {
+		p.Interval = dataCollectionInterval
+	}
+	p.MinInterval = time.Duration(p.MinIntervalInt) * time.Millisecond
+	p.Heartbeat = time.Duration(p.HeartbeatInt) * time.Second
+	return nil
+}
+
+// init validates the gauge algorithm and sets the defaults.
+// The default is the gauge of the interval between the last two pulses.
+func (g *GaugeConfig) init() error {
+	if g.WindowPulses < 0 || g.WindowInt < 0 || g.Alpha < 0 || g.ZeroTimeoutInt < 0 {
+		return fmt.Errorf("gauge values must not be negative")
+	}
+
+	switch g.Algorithm {
+	case "":
+		g.Algorithm = "lastinterval"
+	case "lastinterval", "collection":
+	case "window":
+		if (g.WindowPulses == 0) == (g.WindowInt == 0) {
+			return fmt.Errorf("gauge algorithm window needs either windowpulses or window")
+		}
+		if g.WindowPulses > 1000 {
+			return fmt.Errorf("gauge windowpulses must not be greater than 1000")
+		}
+	case "ewma":
+		if g.Alpha == 0 {
+			g.Alpha = 0.3
+		}
+		if g.Alpha > 1 {
+			return fmt.Errorf("gauge alpha must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unsupported gauge algorithm %q", g.Algorithm)
+	}
+
+	g.Window = time.Duration(g.WindowInt) * time.Second
+	g.ZeroTimeout = time.Duration(g.ZeroTimeoutInt) * time.Second
+	return nil
+}
+
+// init validates the demand register, it's disabled without window.
+// Fixed windows are aligned to the start of the day, so a day must be a multiple of the window.
+func (d *DemandConfig) init() error {
+	if d.WindowInt < 0 {
+		return fmt.Errorf("demand window must not be negative")
+	}
+	d.Window = time.Duration(d.WindowInt) * time.Second
+
+	switch d.Mode {
+	case "":
+		d.Mode = "fixed"
+	case "fixed", "sliding":
+	default:
+		return fmt.Errorf("unsupported demand mode %q", d.Mode)
+	}
+
+	if d.Mode == "fixed" && d.WindowInt > 0 && 86400%d.WindowInt != 0 {
+		return fmt.Errorf("a day must be a multiple of the fixed demand window")
+	}
+	if d.Mode == "sliding" && d.Projection {
+		return fmt.Errorf("demand projection needs mode fixed")
+	}
+	return nil
+}
+
+// templateFuncs are the functions of the payload and webhook templates.
+//  json >> the value as json, e.g. {"meter": {{json .Meter}}} escapes quotes and control characters of the name
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// init validates the payload format and parses the template, the messages are retained by default.
+func (p *PayloadConfig) init() error {
+	switch p.Format {
+	case "":
+		p.Format = "json"
+	case "json", "plain":
+	case "compact":
+		for field, name := range p.Fields {
+			if name == "" {
+				return fmt.Errorf("payload field %q has no name", field)
+			}
+		}
+	case "template":
+		if p.TemplateStr == "" {
+			return fmt.Errorf("payload format template needs a template")
+		}
+
+		var err error
+		if p.Template, err = template.New("payload").Funcs(templateFuncs).Parse(p.TemplateStr); err != nil {
+			return fmt.Errorf("invalid payload template: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported payload format %q", p.Format)
+	}
+
+	if p.Qos > 2 {
+		return fmt.Errorf("unsupported payload qos %v", p.Qos)
+	}
+
+	p.Retained = p.RetainPtr == nil || *p.RetainPtr
+	return nil
+}
+
+// hasTimeStamp returns true, if the payload contains the time stamp of the values.
+// Format plain sends the time stamp to the subtopic timestamp.
+func (p PayloadConfig) hasTimeStamp() bool {
+	switch p.Format {
+	case "compact":
+		return len(p.Fields) == 0 || p.Fields["TimeStamp"] != ""
+	case "template":
+		return strings.Contains(p.TemplateStr, ".TimeStamp")
+	default:
+		return true
+	}
+}
+
+// init validates the alarm rules against the configured meters and converts the hold times.
+func (a *AlarmConfig) init(meters map[string]MeterConfig) error {
+	if a.HistorySize <= 0 {
+		return fmt.Errorf("alarm historysize must be greater than 0")
+	}
+
+	for name, r := range a.Rules {
+		if name == "" || strings.ContainsAny(name, "/+#") {
+			return fmt.Errorf("alarm rule %q: name can't be used as mqtt topic", name)
+		}
+
+		m, ok := meters[r.Meter]
+		if !ok {
+			return fmt.Errorf("alarm rule %q: unknown meter %q", name, r.Meter)
+		}
+
+		switch r.Value {
+		case "":
+			r.Value = "gauge"
+		case "gauge", "counter",
+			"thishour", "lasthour", "today", "yesterday", "thisweek", "lastweek", "thismonth", "lastmonth", "thisyear", "lastyear":
+		case "sincepulse":
+			if m.Expr != nil {
+				return fmt.Errorf("alarm rule %q: virtual meters have no pulses", name)
+			}
+		case "demand":
+			if m.Demand.Window == 0 {
+				return fmt.Errorf("alarm rule %q: meter %q has no demand register", name, r.Meter)
+			}
+		case "projecteddemand":
+			if !m.Demand.Projection {
+				return fmt.Errorf("alarm rule %q: meter %q has no demand projection", name, r.Meter)
+			}
+		default:
+			return fmt.Errorf("alarm rule %q: unsupported value %q", name, r.Value)
+		}
+
+		if r.Condition == "" {
+			r.Condition = ">"
+		}
+		if !alarm.Conditions[r.Condition] {
+			return fmt.Errorf("alarm rule %q: unsupported condition %q", name, r.Condition)
+		}
+
+		switch r.Severity {
+		case "":
+			r.Severity = "warning"
+		case "info", "warning", "critical":
+		default:
+			return fmt.Errorf("alarm rule %q: unsupported severity %q", name, r.Severity)
+		}
+
+		if r.Hysteresis < 0 || r.HoldInt < 0 || r.ClearHoldInt < 0 {
+			return fmt.Errorf("alarm rule %q: hysteresis, hold and clearhold must not be negative", name)
+		}
+		r.Hold = time.Duration(r.HoldInt) * time.Second
+		r.ClearHold = time.Duration(r.ClearHoldInt) * time.Second
+
+		a.Rules[name] = r
+	}
+
+	return nil
+}
+
+// init validates the webhook endpoints, parses the templates and converts the durations.
+// The requests of an endpoint are retried 5 times with a backoff from 1 second up to 5 minutes by default.
+func (w *WebhookConfig) init(meters map[string]MeterConfig) error {
+	if w.BatchIntervalInt <= 0 || w.StallTimeoutInt < 0 {
+		return fmt.Errorf("webhook batchinterval must be greater than 0, stalltimeout must not be negative")
+	}
+	w.BatchInterval = time.Duration(w.BatchIntervalInt) * time.Second
+	w.StallTimeout = time.Duration(w.StallTimeoutInt) * time.Second
+
+	events := map[string]bool{"pulses": true, "period": true, "alarm": true, "stalled": true, "startup": true, "shutdown": true}
+
+	for name, e := range w.Endpoints {
+		if e.URL == "" {
+			return fmt.Errorf("webhook %q: url is missing", name)
+		}
+
+		// without events, all events are posted
+		e.EventFilter = map[string]bool{}
+		for _, ev := range e.Events {
+			if !events[ev] {
+				return fmt.Errorf("webhook %q: unsupported event %q", name, ev)
+			}
+			e.EventFilter[ev] = true
+		}
+		if len(e.EventFilter) == 0 {
+			e.EventFilter = events
+		}
+		if e.EventFilter["stalled"] && w.StallTimeout == 0 {
+			return fmt.Errorf("webhook %q: event stalled needs a stalltimeout", name)
+		}
+
+		// without meters, the events of all meters are posted
+		e.MeterFilter = map[string]bool{}
+		for _, m := range e.Meters {
+			if _, ok := meters[m]; !ok {
+				return fmt.Errorf("webhook %q: unknown meter %q", name, m)
+			}
+			e.MeterFilter[m] = true
+		}
+
+		if e.TemplateStr != "" {
+			var err error
+			if e.Template, err = template.New(name).Funcs(templateFuncs).Parse(e.TemplateStr); err != nil {
+				return fmt.Errorf("webhook %q: invalid template: %w", name, err)
+			}
+		}
+		if e.ContentType == "" {
+			e.ContentType = "application/json"
+		}
+
+		if e.TimeoutInt == 0 {
+			e.TimeoutInt = 10
+		}
+		if e.BackoffInt == 0 {
+			e.BackoffInt = 1
+		}
+		if e.MaxBackoffInt == 0 {
+			e.MaxBackoffInt = 300
+		}
+		e.Retries = 5
+		if e.RetriesPtr != nil {
+			e.Retries = *e.RetriesPtr
+		}
+		if e.TimeoutInt < 0 || e.BackoffInt < 0 || e.MaxBackoffInt < 0 || e.Retries < 0 {
+			return fmt.Errorf("webhook %q: timeout, retries, backoff and maxbackoff must not be negative", name)
+		}
+		e.Timeout = time.Duration(e.TimeoutInt) * time.Second
+		e.Backoff = time.Duration(e.BackoffInt) * time.Second
+		e.MaxBackoff = time.Duration(e.MaxBackoffInt) * time.Second
+
+		w.Endpoints[name] = e
+	}
+
+	return nil
+}
+
+// checkGpios checks that each gpio of a chip is used by one S0 input only, the same gpio can be used on different chips.
+func (c *Config) checkGpios() error {
+	names := make([]string, 0, len(c.Meter))
+	for name := range c.Meter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type line struct {
+		chip string
+		gpio int
+	}
+	used := map[line]string{}
+	for _, name := range names {
+		m := c.Meter[name]
+		if m.Expression != "" {
+			continue
+		}
+
+		// meters without chip use the default chip
+		chip := m.Chip
+		if chip == "" {
+			chip = c.GPIO.Chip
+		}
+
+		gpios := []int{m.Gpio}
+		if m.Bidirectional() {
+			gpios = append(gpios, m.ExportGpio)
+		}
+		for _, g := range gpios {
+			l := line{chip: chip, gpio: g}
+			if other, ok := used[l]; ok {
+				return fmt.Errorf("meter %q: gpio %v of %v is already used by meter %q", name, g, chip, other)
+			}
+			used[l] = name
+		}
+	}
+	return nil
+}
+
+// initVirtualMeters parses the expressions of the virtual meters and validates the referenced meters and the units.
+// The expressions must be linear combinations of meters with the same units and mustn't contain cycles.
+// If a virtual meter has no units, the units of the referenced meters are used.
+func (c *Config) initVirtualMeters() error {
+	names := make([]string, 0, len(c.Meter))
+	for name, m := range c.Meter {
+		if m.Expression == "" {
+			continue
+		}
+
+		e, err := expression.Parse(m.Expression)
+		if err != nil {
+			return fmt.Errorf("meter %q: invalid expression: %w", name, err)
+		}
+		if !e.Linear() {
+			return fmt.Errorf("meter %q: expression isn't a linear combination of meters, the unit would change", name)
+		}
+		if len(e.Variables()) == 0 {
+			return fmt.Errorf("meter %q: expression doesn't reference a meter", name)
+		}
+		for _, v := range e.Variables() {
+			if _, ok := c.Meter[v]; !ok {
+				return fmt.Errorf("meter %q: expression references unknown meter %q", name, v)
+			}
+		}
+		if m.Tariff {
+			return fmt.Errorf("meter %q: virtual meters don't support tariffs", name)
+		}
+		if m.Offset != 0 {
+			return fmt.Errorf("meter %q: virtual meters don't support an offset", name)
+		}
+		if m.Demand.Window > 0 {
+			return fmt.Errorf("meter %q: virtual meters don't support a demand register", name)
+		}
+		if m.Publish.Policy == "pulse" {
+			return fmt.Errorf("meter %q: virtual meters don't support the publish policy pulse", name)
+		}
+
+		m.Expr = e
+		c.Meter[name] = m
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// state of the depth-first search: 1 >> in progress, 2 >> units are resolved
+	state := map[string]int{}
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		m := c.Meter[name]
+		switch {
+		case m.Expr == nil || state[name] == 2:
+			return nil
+		case state[name] == 1:
+			return fmt.Errorf("meter %q: cyclic expression", name)
+		}
+
+		state[name] = 1
+		unitCounter, unitGauge := m.UnitCounter, m.UnitGauge
+		for i, v := range m.Expr.Variables() {
+			if err := resolve(v); err != nil {
+				return err
+			}
+
+			o := c.Meter[v]
+			if i == 0 && unitCounter == "" && unitGauge == "" {
+				unitCounter, unitGauge = o.UnitCounter, o.UnitGauge
+			}
+			if o.UnitCounter != unitCounter || o.UnitGauge != unitGauge {
+				return fmt.Errorf("meter %q: units %v, %v of meter %q don't match %v, %v", name, o.UnitCounter, o.UnitGauge, v, unitCounter, unitGauge)
+			}
+		}
+
+		m.UnitCounter, m.UnitGauge = unitCounter, unitGauge
+		c.Meter[name] = m
+		state[name] = 2
+		return nil
+	}
+
+	for _, name := range names {
+		if err := resolve(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validSparkplugID returns true, if the id can be used as part of a sparkplug topic.
+func validSparkplugID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/+#")
+}
+
+// setDeviceClass validates the device class of the meter, if it isn't defined, it's derived from the unit of the counter.
+func (m *MeterConfig) setDeviceClass() error {
+	switch m.DeviceClass {
+	case "":
+		switch {
+		case strings.HasSuffix(m.UnitCounter, "Wh"):
+			m.DeviceClass = "energy"
+		case m.UnitCounter == "l" || m.UnitCounter == "m³":
+			m.DeviceClass = "water"
+		}
+	case "energy", "water", "gas":
+	default:
+		return fmt.Errorf("unsupported deviceclass %q", m.DeviceClass)
+	}
+	return nil
+}
+
+// init validates the simulation profile and converts the durations.
+func (s *SimulationConfig) init() error {
+	switch s.Profile {
+	case "constant", "poisson":
+		if s.Rate <= 0 {
+			return fmt.Errorf("simulation rate must be greater than 0")
+		}
+	case "sequence":
+		if len(s.SequenceInt) == 0 {
+			return fmt.Errorf("simulation sequence is empty")
+		}
+	default:
+		return fmt.Errorf("unsupported simulation profile %q", s.Profile)
+	}
+
+	s.Sequence = nil
+	for _, i := range s.SequenceInt {
+		if i <= 0 {
+			return fmt.Errorf("simulation sequence intervals must be greater than 0")
+		}
+		s.Sequence = append(s.Sequence, time.Duration(i)*time.Millisecond)
+	}
+
+	// the minimum S0 pulse length is 30ms (DIN 43864)
+	if s.PulseWidthInt <= 0 {
+		s.PulseWidthInt = 30
+	}
+	s.PulseWidth = time.Duration(s.PulseWidthInt) * time.Millisecond
 	return nil
 }
 
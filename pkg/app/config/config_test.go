@@ -0,0 +1,175 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateFuncJSON(t *testing.T) {
+	tmpl, err := template.New("test").Funcs(templateFuncs).Parse(`{"meter": {{json .Meter}}, "message": {{json .Message}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := struct{ Meter, Message string }{Meter: `boiler "main"`, Message: "line1\nline2\\"}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, data); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct{ Meter, Message string }
+	if err = json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatalf("invalid json %q: %v", b.String(), err)
+	}
+	if got != data {
+		t.Errorf("got %+v, want %+v", got, data)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	for token, valid := range map[string]bool{"": false, "secret": true} {
+		yaml := "webserver:\n  webservices:\n    admin: true\n  admintoken: \"" + token + "\"\n"
+		if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewConfig()
+		c.Flag.ConfigFile = file
+		if err := c.LoadConfig(); (err == nil) != valid {
+			t.Errorf("admintoken %q: LoadConfig = %v", token, err)
+		}
+	}
+}
+
+func TestVirtualMeters(t *testing.T) {
+	const meters = `
+meter:
+  a:
+    gpio: 17
+    counterconstant: 1000
+    unitcounter: kWh
+    unitgauge: kW
+  b:
+    gpio: 18
+    counterconstant: 1000
+    unitcounter: kWh
+    unitgauge: kW
+  water:
+    gpio: 19
+    counterconstant: 1
+    unitcounter: l
+    unitgauge: l/h
+`
+	file := filepath.Join(t.TempDir(), "config.yaml")
+
+	for _, tc := range []struct {
+		virtual string
+		err     string
+	}{
+		{"  sum:\n    expression: \"a + b * 0.5\"\n", ""},
+		{"  sum:\n    expression: \"a - b\"\n  total:\n    expression: \"sum + a\"\n", ""},
+		{"  sum:\n    expression: \"a * b\"\n", "isn't a linear combination"},
+		{"  sum:\n    expression: \"1 / a\"\n", "isn't a linear combination"},
+		{"  sum:\n    expression: \"a + \"\n", "invalid expression"},
+		{"  sum:\n    expression: \"10\"\n", "doesn't reference a meter"},
+		{"  sum:\n    expression: \"a + gas\"\n", "unknown meter \"gas\""},
+		{"  sum:\n    expression: \"a + water\"\n", "don't match"},
+		{"  sum:\n    expression: \"a + sum\"\n", "cyclic expression"},
+		{"  x:\n    expression: \"a + y\"\n  y:\n    expression: \"b + z\"\n  z:\n    expression: \"x\"\n", "cyclic expression"},
+	} {
+		if err := os.WriteFile(file, []byte(meters+tc.virtual), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewConfig()
+		c.Flag.ConfigFile = file
+		err := c.LoadConfig()
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: LoadConfig = %v", tc.virtual, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: LoadConfig = %v, want error %q", tc.virtual, err, tc.err)
+		}
+	}
+}
+
+func TestVirtualMeterUnits(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+meter:
+  a:
+    gpio: 17
+    counterconstant: 1000
+    unitcounter: kWh
+    unitgauge: kW
+  sum:
+    expression: "a * 2"
+  total:
+    expression: "sum - a"
+`
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig()
+	c.Flag.ConfigFile = file
+	if err := c.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the units are inherited from the referenced meters, also over several virtual meters
+	for _, name := range []string{"sum", "total"} {
+		if m := c.Meter[name]; m.UnitCounter != "kWh" || m.UnitGauge != "kW" || m.Expr == nil {
+			t.Errorf("meter %v: units %q, %q, expression %v, want kWh, kW", name, m.UnitCounter, m.UnitGauge, m.Expr)
+		}
+	}
+}
+
+func TestCommandToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	for token, valid := range map[string]bool{"": false, "secret": true} {
+		yaml := "mqtt:\n  commands:\n    enabled: true\n    token: \"" + token + "\"\n"
+		if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewConfig()
+		c.Flag.ConfigFile = file
+		if err := c.LoadConfig(); (err == nil) != valid {
+			t.Errorf("token %q: LoadConfig = %v", token, err)
+		}
+	}
+}
+
+func TestGpiosPerChip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	for _, tc := range []struct {
+		chips [2]string
+		valid bool
+	}{
+		{[2]string{"", ""}, false},
+		{[2]string{"", "gpiochip0"}, false},
+		{[2]string{"", "gpiochip4"}, true},
+		{[2]string{"gpiochip4", "gpiochip4"}, false},
+	} {
+		yaml := "gpio:\n  driver: gpiod\n  chip: gpiochip0\nmeter:\n"
+		for i, chip := range tc.chips {
+			yaml += fmt.Sprintf("  m%v:\n    gpio: 17\n    counterconstant: 1000\n    chip: %q\n", i, chip)
+		}
+		if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewConfig()
+		c.Flag.ConfigFile = file
+		if err := c.LoadConfig(); (err == nil) != tc.valid {
+			t.Errorf("chips %q: LoadConfig = %v, want valid %v", tc.chips, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"s0counter/pkg/demand"
+	"s0counter/pkg/meter"
+	"time"
+)
+
+// demandRecord contains the average power of the current window and the maximum demand of the meter in the unit of the gauge.
+type demandRecord struct {
+	Current   float64      // average power of the current window, a fixed window is averaged over the whole window
+	Projected *float64     `json:",omitempty"` // projected average power at the end of the current fixed window
+	Last      *demandValue `json:",omitempty"` // average power of the last complete fixed window
+	Today     demandValue  // maximum demand of the current day
+	Yesterday demandValue  // maximum demand of the previous day
+	ThisMonth demandValue  // maximum demand of the current month
+	LastMonth demandValue  // maximum demand of the previous month
+}
+
+// demandValue is the average power of a window.
+type demandValue struct {
+	Value     float64   // average power of the window, e.g. kW
+	TimeStamp time.Time // end of the window
+}
+
+// updateDemand closes the fixed window or moves the sliding window of the demand register to time t.
+// The demand register counts the import of a bidirectional meter.
+// The meter must be locked by the caller.
+func (app *App) updateDemand(m *meter.Meter, t time.Time) {
+	c := m.Config.Demand
+	if c.Window == 0 {
+		return
+	}
+
+	m.S0.Demand.Update(c.Window, c.Mode == "sliding", t.In(app.config.Location), m.S0.Tick)
+}
+
+// calcDemand returns the demand of the meter at time t, it's nil if the meter has no demand register.
+// The registers of the meter aren't changed, so a read lock of the meter is sufficient.
+func (app *App) calcDemand(m *meter.Meter, t time.Time) *demandRecord {
+	c := m.Config.Demand
+	if c.Window == 0 {
+		return nil
+	}
+
+	// the average power of the ticks of a window, e.g. 250 ticks in 15 minutes with 1000 ticks/kWh >> 1 kW
+	power := func(ticks uint64) float64 {
+		return toFixed(float64(ticks)/m.Config.CounterConstant*3600/c.Window.Seconds()*m.Config.ScaleFactor, m.Config.Precision)
+	}
+	value := func(v demand.Value) demandValue {
+		return demandValue{Value: power(v.Ticks), TimeStamp: v.TimeStamp}
+	}
+
+	// the register is updated on a copy, so the day and month of the maximum demand are current
+	r := m.S0.Demand.At(c.Window, c.Mode == "sliding", t.In(app.config.Location), m.S0.Tick)
+
+	d := &demandRecord{
+		Current:   power(r.Ticks(c.Mode == "sliding", m.S0.Tick)),
+		Today:     value(r.Today),
+		Yesterday: value(r.Yesterday),
+		ThisMonth: value(r.ThisMonth),
+		LastMonth: value(r.LastMonth),
+	}
+
+	if c.Mode == "fixed" {
+		last := value(r.Last)
+		d.Last = &last
+	}
+
+	// the remaining time of the window is extrapolated with the current gauge
+	if c.Projection {
+		remaining := demand.Start(c.Window, r.Start).Add(c.Window).Sub(t)
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		p := toFixed(d.Current+registerGauge(m.S0, m.Config, t)*remaining.Seconds()/c.Window.Seconds(), m.Config.Precision)
+		d.Projected = &p
+	}
+	return d
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"encoding/json"
+	"regexp"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/mqtt"
+	"strings"
+
+	"github.com/womat/debug"
+)
+
+// invalidObjectID matches the characters which aren't allowed in a home assistant object id
+var invalidObjectID = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// discoverySensor is the home assistant mqtt discovery configuration of a sensor.
+type discoverySensor struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	ValueTemplate     string          `json:"value_template"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// discoveryDevice is the home assistant device of all sensors.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version"`
+}
+
+// initDiscovery announces the sensors after every (re)connect and removes the sensors of removed meters.
+// The retained discovery configurations of the node are subscribed, each configuration which doesn't
+// belong to a configured meter is removed.
+func (app *App) initDiscovery() error {
+	if !app.config.MQTT.Discovery.Enabled {
+		return nil
+	}
+
+	app.mqtt.OnConnect(app.announceSensors)
+	return app.mqtt.Subscribe(app.discoveryTopic("+"), 0, app.removeStaleSensor)
+}
+
+// announceSensors publishes the retained discovery configuration of the counter and the gauge of each meter.
+func (app *App) announceSensors() {
+	for _, s := range app.discoverySensors() {
+		b, err := json.Marshal(s)
+		if err != nil {
+			debug.ErrorLog.Printf("discovery marshal: %v", err)
+			continue
+		}
+
+		debug.DebugLog.Printf("announce sensor %v", s.UniqueID)
+		app.mqtt.C <- mqtt.Message{
+			Qos:      1,
+			Retained: true,
+			Topic:    app.discoveryTopic(s.objectID(app.config.MQTT.Discovery.NodeID)),
+			Payload:  b,
+		}
+	}
+}
+
+// removeStaleSensor removes a received discovery configuration, if the sensor isn't announced anymore.
+func (app *App) removeStaleSensor(msg mqtt.Message) {
+	// an empty payload is the removal of a sensor
+	if len(msg.Payload) == 0 {
+		return
+	}
+
+	t := strings.Split(msg.Topic, "/")
+	if len(t) < 2 {
+		return
+	}
+	objectID := t[len(t)-2]
+
+	for _, s := range app.discoverySensors() {
+		if s.objectID(app.config.MQTT.Discovery.NodeID) == objectID {
+			return
+		}
+	}
+
+	debug.InfoLog.Printf("remove sensor %v of a removed meter", objectID)
+	go func() {
+		app.mqtt.C <- mqtt.Message{
+			Qos:      1,
+			Retained: true,
+			Topic:    msg.Topic,
+			Payload:  []byte{},
+		}
+	}()
+}
+
+// discoverySensors returns the counter and gauge sensors of all meters with a mqtt topic.
+// Bidirectional meters have an import and an export sensor additionally.
+// The state class of a counter, which can decrease, is total instead of total_increasing.
+func (app *App) discoverySensors() []discoverySensor {
+	nodeID := app.config.MQTT.Discovery.NodeID
+	device := discoveryDevice{
+		Identifiers:  []string{nodeID},
+		Name:         nodeID,
+		Manufacturer: "womat",
+		Model:        MODULE,
+		SWVersion:    VERSION,
+	}
+
+	var sensors []discoverySensor
+	for name, m := range app.config.Meter {
+		if m.MqttTopic == "" {
+			continue
+		}
+
+		id := nodeID + "_" + invalidObjectID.ReplaceAllString(name, "_")
+		stateClass := "total_increasing"
+		if decreasing(app.config.Meter, name) {
+			stateClass = "total"
+		}
+		if topic, value, ok := sensorState(m, "Counter"); ok {
+			sensors = append(sensors, discoverySensor{
+				Name:              name + " counter",
+				UniqueID:          id + "_counter",
+				StateTopic:        topic,
+				ValueTemplate:     value,
+				UnitOfMeasurement: haUnit(m.UnitCounter),
+				DeviceClass:       m.DeviceClass,
+				StateClass:        stateClass,
+				AvailabilityTopic: app.config.MQTT.StatusTopic,
+				Device:            device,
+			})
+		}
+		if topic, value, ok := sensorState(m, "Gauge"); ok {
+			sensors = append(sensors, discoverySensor{
+				Name:              name + " gauge",
+				UniqueID:          id + "_gauge",
+				StateTopic:        topic,
+				ValueTemplate:     value,
+				UnitOfMeasurement: haUnit(m.UnitGauge),
+				DeviceClass:       gaugeDeviceClass(m.DeviceClass),
+				StateClass:        "measurement",
+				AvailabilityTopic: app.config.MQTT.StatusTopic,
+				Device:            device,
+			})
+		}
+		if !m.Bidirectional() {
+			continue
+		}
+		for _, d := range []string{"Import", "Export"} {
+			if topic, value, ok := sensorState(m, d+".Counter"); ok {
+				sensors = append(sensors, discoverySensor{
+					Name:              name + " " + strings.ToLower(d),
+					UniqueID:          id + "_" + strings.ToLower(d),
+					StateTopic:        topic,
+					ValueTemplate:     value,
+					UnitOfMeasurement: haUnit(m.UnitCounter),
+					DeviceClass:       m.DeviceClass,
+					StateClass:        "total_increasing",
+					AvailabilityTopic: app.config.MQTT.StatusTopic,
+					Device:            device,
+				})
+			}
+		}
+	}
+	return sensors
+}
+
+// sensorState returns the state topic and the value template of a field (Counter, Gauge, Import.Counter, ...) in the payload format of the meter.
+// If the field isn't part of the payload (e.g. format template), ok is false and the sensor isn't announced.
+func sensorState(m config.MeterConfig, field string) (topic, value string, ok bool) {
+	switch m.Payload.Format {
+	case "plain":
+		return m.MqttTopic + "/" + strings.ToLower(strings.ReplaceAll(field, ".", "/")), "{{ value }}", true
+	case "compact":
+		// the fields of compact can only rename the top level fields, e.g. Import of Import.Counter
+		name, sub := field, ""
+		if i := strings.Index(field, "."); i >= 0 {
+			name, sub = field[:i], field[i+1:]
+		}
+		if len(m.Payload.Fields) > 0 {
+			if name, ok = m.Payload.Fields[name]; !ok {
+				return "", "", false
+			}
+		}
+		value = "value_json['" + name + "']"
+		if sub != "" {
+			value += "['" + sub + "']"
+		}
+		return m.MqttTopic, "{{ " + value + " }}", true
+	case "template":
+		return "", "", false
+	default:
+		return m.MqttTopic, "{{ value_json." + field + " }}", true
+	}
+}
+
+// discoveryTopic returns the topic of the discovery configuration of a sensor.
+func (app *App) discoveryTopic(objectID string) string {
+	d := app.config.MQTT.Discovery
+	return d.Prefix + "/sensor/" + d.NodeID + "/" + objectID + "/config"
+}
+
+// objectID returns the object id of the sensor, it's the unique id without the node id.
+func (s discoverySensor) objectID(nodeID string) string {
+	return strings.TrimPrefix(s.UniqueID, nodeID+"_")
+}
+
+// gaugeDeviceClass returns the home assistant device class of the gauge of a counter device class.
+func gaugeDeviceClass(counterClass string) string {
+	switch counterClass {
+	case "energy":
+		return "power"
+	case "water", "gas":
+		return "volume_flow_rate"
+	default:
+		return ""
+	}
+}
+
+// haUnit returns the unit in home assistant notation, home assistant uses L for liters, e.g. l/h >> L/h
+func haUnit(unit string) string {
+	if unit == "l" || strings.HasPrefix(unit, "l/") {
+		return "L" + unit[1:]
+	}
+	return unit
+}
+
+// decreasing returns true, if the counter of the meter can decrease: the net counter of a bidirectional meter
+// and a virtual meter with a negative coefficient or an operand, which can decrease.
+// The expressions of the virtual meters are validated, so they are linear and acyclic.
+func decreasing(meters map[string]config.MeterConfig, name string) bool {
+	m := meters[name]
+	if m.Bidirectional() {
+		return true
+	}
+	if m.Expr == nil {
+		return false
+	}
+
+	for v, f := range m.Expr.Coefficients() {
+		if f < 0 || decreasing(meters, v) {
+			return true
+		}
+	}
+	return false
+}
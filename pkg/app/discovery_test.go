@@ -0,0 +1,42 @@
+package app
+
+import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/expression"
+	"testing"
+)
+
+func TestDecreasing(t *testing.T) {
+	meters := map[string]config.MeterConfig{
+		"grid":    {Type: "bidirectional", ExportGpio: 18},
+		"pv":      {},
+		"wallbox": {},
+	}
+	for name, src := range map[string]string{
+		"sum":        "pv + wallbox * 0.5",
+		"difference": "pv - wallbox",
+		"negated":    "-(pv + wallbox)",
+		"house":      "grid + pv",
+		"total":      "sum + difference",
+	} {
+		e, err := expression.Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meters[name] = config.MeterConfig{Expression: src, Expr: e}
+	}
+
+	for name, want := range map[string]bool{
+		"grid":       true,
+		"pv":         false,
+		"sum":        false,
+		"difference": true,
+		"negated":    true,
+		"house":      true,
+		"total":      true,
+	} {
+		if got := decreasing(meters, name); got != want {
+			t.Errorf("decreasing(%v) = %v, want %v", name, got, want)
+		}
+	}
+}
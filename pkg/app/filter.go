@@ -0,0 +1,58 @@
+package app
+
+import (
+	"s0counter/pkg/meter"
+	"time"
+
+	"github.com/womat/debug"
+)
+
+// filter checks if the edge at time t completes a plausible pulse which has to be counted.
+// In countmode pulse, a full pulse is counted only once, when the level changes back from active to inactive,
+// and the pulse width is checked. Pulses out of the configured pulse width or interval are rejected,
+// e.g. EMI spikes which are shorter than the minimum S0 pulse length of 30ms (DIN 43864).
+// A release edge without a preceding active edge is rejected, except the first edge after startup,
+// the pulse width of a spike, whose active edge was missed, can't be checked.
+// The register r is the register of the meter, which counts the pulses of the pin.
+// The meter must be locked by the caller.
+func filter(m *meter.Meter, r *meter.S0, pin int, t time.Time, active bool) bool {
+	f := m.Config.Filter
+
+	if m.Config.CountMode == "pulse" {
+		first := !r.EdgeSeen
+		r.EdgeSeen = true
+
+		if active {
+			r.PulseStart = t
+			return false
+		}
+
+		if r.PulseStart.IsZero() {
+			// the pulse was already active at startup, its width is unknown
+			if !first {
+				r.Rejected++
+				debug.DebugLog.Printf("reject pulse on pin %v: release without active edge", pin)
+				return false
+			}
+		} else {
+			w := t.Sub(r.PulseStart)
+			r.PulseStart = time.Time{}
+
+			if w < f.MinPulseWidth || (f.MaxPulseWidth > 0 && w > f.MaxPulseWidth) {
+				r.Rejected++
+				debug.DebugLog.Printf("reject pulse on pin %v: pulse width %v", pin, w)
+				return false
+			}
+		}
+	}
+
+	if f.MinInterval > 0 && !r.TimeStamp.IsZero() {
+		if i := t.Sub(r.TimeStamp); i < f.MinInterval {
+			r.Rejected++
+			debug.DebugLog.Printf("reject pulse on pin %v: interval %v", pin, i)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"testing"
+	"time"
+)
+
+func TestFilterPulseWidth(t *testing.T) {
+	m := &meter.Meter{Config: config.MeterConfig{CountMode: "pulse", Filter: config.FilterConfig{MinPulseWidth: 30 * time.Millisecond}}}
+	r := &m.S0
+	t0 := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		at     time.Duration
+		active bool
+		count  bool
+	}{
+		// the first edge after startup is a release, the pulse was already active
+		{at: 0, active: false, count: true},
+		// a valid pulse of 50ms
+		{at: time.Second, active: true, count: false},
+		{at: time.Second + 50*time.Millisecond, active: false, count: true},
+		// a spike of 2ms
+		{at: 2 * time.Second, active: true, count: false},
+		{at: 2*time.Second + 2*time.Millisecond, active: false, count: false},
+		// a release without active edge, e.g. a spike whose active edge was missed
+		{at: 3 * time.Second, active: false, count: false},
+	}
+
+	for i, s := range steps {
+		if got := filter(m, r, 17, t0.Add(s.at), s.active); got != s.count {
+			t.Errorf("step %v: filter = %v, want %v", i, got, s.count)
+		}
+	}
+
+	if r.Rejected != 2 {
+		t.Errorf("rejected = %v, want 2", r.Rejected)
+	}
+}
+
+func TestFilterMinInterval(t *testing.T) {
+	m := &meter.Meter{Config: config.MeterConfig{CountMode: "edge", Filter: config.FilterConfig{MinInterval: 100 * time.Millisecond}}}
+	r := &m.S0
+	t0 := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	r.TimeStamp = t0
+	if filter(m, r, 17, t0.Add(50*time.Millisecond), false) {
+		t.Error("pulse within the minimum interval isn't rejected")
+	}
+	if !filter(m, r, 17, t0.Add(150*time.Millisecond), false) {
+		t.Error("pulse after the minimum interval is rejected")
+	}
+	if r.Rejected != 1 {
+		t.Errorf("rejected = %v, want 1", r.Rejected)
+	}
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"time"
+)
+
+// registerGauge returns the gauge of the s0 register calculated by the gauge algorithm of the meter.
+//  lastinterval: duration between the last two pulses
+//  window: average of the last windowpulses pulses or of the pulses of the last window seconds
+//  ewma: exponentially weighted moving average of the pulse intervals
+//  collection: pulses of the last data collection interval
+// If no pulse has been received for the zero flow timeout at time now, the gauge is 0.
+func registerGauge(r meter.S0, c config.MeterConfig, now time.Time) float64 {
+	if g := c.Gauge; g.ZeroTimeout > 0 && now.Sub(r.TimeStamp) > g.ZeroTimeout {
+		return 0
+	}
+
+	var rate float64 // pulses per second
+	switch c.Gauge.Algorithm {
+	case "window":
+		rate = windowRate(r, c.Gauge, now)
+	case "ewma":
+		rate = ewmaRate(r, now)
+	case "collection":
+		rate = r.Gauge.Rate
+	default:
+		rate = lastIntervalRate(r, now)
+	}
+
+	// P = 3600 / (t * Cz) (Zählerkonstante in Imp/kWh)
+	return rate * 3600 / c.CounterConstant * c.ScaleFactor
+}
+
+// lastIntervalRate returns the pulse rate of the duration between the last two pulses.
+func lastIntervalRate(r meter.S0, now time.Time) float64 {
+	dt := r.TimeStamp.Sub(r.LastTimeStamp) // duration between last two ticks
+
+	// if duration between "now and last tick" is greater than the duration between "last two ticks"
+	// increase duration between "last two ticks" to duration between "now and the penultimate tick".
+	// this ensures that the value of the gauge value changes if no new pulse has been received
+	if now.Sub(r.TimeStamp) > dt {
+		dt = now.Sub(r.LastTimeStamp)
+	}
+
+	return 1 / dt.Seconds()
+}
+
+// windowRate returns the average pulse rate of the sliding window.
+// A window of pulses is extended to now, if the time since the last pulse is longer than the average pulse interval.
+func windowRate(r meter.S0, g config.GaugeConfig, now time.Time) float64 {
+	p := r.Gauge.Pulses
+
+	if g.Window > 0 {
+		n := 0
+		for _, t := range p {
+			if now.Sub(t) <= g.Window {
+				n++
+			}
+		}
+		return float64(n) / g.Window.Seconds()
+	}
+
+	if len(p) < 2 {
+		return 0
+	}
+
+	n := float64(len(p) - 1) // intervals of the window
+	span := p[len(p)-1].Sub(p[0])
+	if now.Sub(p[len(p)-1]).Seconds() > span.Seconds()/n {
+		span = now.Sub(p[0])
+	}
+	return n / span.Seconds()
+}
+
+// ewmaRate returns the pulse rate of the smoothed pulse interval,
+// it decreases like the last interval rate if no new pulse has been received.
+func ewmaRate(r meter.S0, now time.Time) float64 {
+	if r.Gauge.Interval == 0 {
+		return 0
+	}
+
+	if since := now.Sub(r.TimeStamp).Seconds(); since > r.Gauge.Interval {
+		return 1 / since
+	}
+	return 1 / r.Gauge.Interval
+}
+
+// updateGauge updates the state of the gauge algorithm after the pulse of register r at time t.
+// The meter must be locked by the caller.
+func updateGauge(r *meter.S0, g config.GaugeConfig, t time.Time) {
+	switch g.Algorithm {
+	case "window":
+		p := append(r.Gauge.Pulses, t)
+		switch {
+		case g.Window > 0:
+			// remove the pulses, which are out of the time window
+			i := 0
+			for i < len(p) && t.Sub(p[i]) > g.Window {
+				i++
+			}
+			p = p[i:]
+		case len(p) > g.WindowPulses+1:
+			// windowpulses intervals need windowpulses + 1 pulses
+			p = p[len(p)-g.WindowPulses-1:]
+		}
+		r.Gauge.Pulses = p
+
+	case "ewma":
+		if r.LastTimeStamp.IsZero() {
+			return
+		}
+
+		// the intervals are smoothed instead of the rates, the mean of the rates of random pulses is distorted by short intervals
+		dt := r.TimeStamp.Sub(r.LastTimeStamp).Seconds()
+		if r.Gauge.Interval == 0 {
+			r.Gauge.Interval = dt
+			return
+		}
+		r.Gauge.Interval = g.Alpha*dt + (1-g.Alpha)*r.Gauge.Interval
+	}
+}
+
+// collectGauge calculates the pulse rate of the data collection interval, which ends at time t (algorithm collection).
+// The meter must be locked by the caller.
+func collectGauge(r *meter.S0, g config.GaugeConfig, t time.Time) {
+	if g.Algorithm != "collection" {
+		return
+	}
+
+	// the ticks can be decreased by the command setcounter
+	if !r.Gauge.TimeStamp.IsZero() && r.Tick >= r.Gauge.Tick {
+		if dt := t.Sub(r.Gauge.TimeStamp).Seconds(); dt > 0 {
+			r.Gauge.Rate = float64(r.Tick-r.Gauge.Tick) / dt
+		}
+	}
+	r.Gauge.Tick, r.Gauge.TimeStamp = r.Tick, t
+}
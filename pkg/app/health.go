@@ -31,6 +31,13 @@ func (app *App) HandleHealth() fiber.Handler {
 		hab := m.Alloc
 		smb := m.Sys
 
+		rejected := map[string]uint64{}
+		for n, m := range app.meters {
+			m.RLock()
+			rejected[n] = m.S0.Rejected + m.Export.Rejected
+			m.RUnlock()
+		}
+
 		healthData := struct {
 			NumGoroutines      int
 			HeapAllocatedBytes uint64
@@ -41,6 +48,8 @@ func (app *App) HandleHealth() fiber.Handler {
 			ProgLang           string
 			HostName           string
 			Time               string
+			RejectedPulses     map[string]uint64
+			MQTTQueueDepth     int
 		}{
 			NumGoroutines:      runtime.NumGoroutine(),
 			HeapAllocatedBytes: hab,
@@ -51,6 +60,8 @@ func (app *App) HandleHealth() fiber.Handler {
 			Version:            VERSION,
 			HostName:           host,
 			Time:               time.Now().Format(time.RFC3339),
+			RejectedPulses:     rejected,
+			MQTTQueueDepth:     app.mqtt.QueueDepth(),
 		}
 		ctx.Status(http.StatusOK)
 		return ctx.JSON(healthData)
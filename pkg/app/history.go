@@ -0,0 +1,150 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/history"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/womat/debug"
+)
+
+// historyResp is the response of the history webservice
+type historyResp struct {
+	Meter       string          // name of the meter
+	UnitCounter string          // unit of counter e.g. kWh, l, m³
+	Points      []history.Point // counters of the requested period
+}
+
+// openHistory opens the history store, if a history file is defined.
+// The intervals of the store are aligned to the location of the calendar periods.
+func openHistory(c config.HistoryConfig, loc *time.Location) (*history.Store, error) {
+	if c.File == "" {
+		return nil, nil
+	}
+
+	var rules []history.Rule
+	for _, r := range c.Rules {
+		rules = append(rules, history.Rule{Resolution: r.Resolution, Retention: r.Retention})
+	}
+
+	return history.Open(c.File, rules, loc, c.MaxPoints)
+}
+
+// recordHistory stores the counters of all meters in the history store with the finest resolution
+// and deletes the expired counters.
+func (app *App) recordHistory() {
+	for range time.Tick(app.historyResolution()) {
+		t := app.now()
+
+		for n, m := range app.meters {
+			m.RLock()
+			c := calcCounter(m)
+			m.RUnlock()
+
+			if err := app.history.Add(n, t, c); err != nil {
+				debug.ErrorLog.Printf("can't add history of meter %v: %v", n, err)
+			}
+		}
+
+		if err := app.history.Prune(t); err != nil {
+			debug.ErrorLog.Printf("can't prune history: %v", err)
+		}
+	}
+}
+
+// historyResolution returns the finest resolution of the history store.
+func (app *App) historyResolution() (r time.Duration) {
+	for _, rule := range app.config.History.Rules {
+		if r == 0 || rule.Resolution < r {
+			r = rule.Resolution
+		}
+	}
+	return
+}
+
+// HandleHistory returns the stored counters of a meter.
+// Parameters:
+//  from, to >> period in RFC3339 format or unix time (default: last 24 hours)
+//  step >> resolution of the counters e.g. 15m, 1h or seconds (default: finest resolution of the history),
+//          the steps are aligned to the timezone, the period must not contain more than maxpoints steps
+//  format >> json (default), csv
+func (app *App) HandleHistory() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request history")
+
+		name := ctx.Params("meter")
+		m, ok := app.meters[name]
+		if !ok {
+			return fiber.NewError(http.StatusNotFound, fmt.Sprintf("unknown meter %q", name))
+		}
+
+		to, err := parseTime(ctx.Query("to"), app.now())
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+		from, err := parseTime(ctx.Query("from"), to.Add(-24*time.Hour))
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+
+		step := app.historyResolution()
+		if s := ctx.Query("step"); s != "" {
+			if step, err = parseDuration(s); err != nil || step <= 0 {
+				return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("invalid step %q", s))
+			}
+		}
+
+		points, err := app.history.Query(name, from, to, step)
+		switch {
+		case errors.Is(err, history.ErrUnknownMeter):
+			points = []history.Point{}
+		case errors.Is(err, history.ErrTooManyPoints):
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		case err != nil:
+			debug.ErrorLog.Printf("can't query history of meter %v: %v", name, err)
+			return fiber.NewError(http.StatusInternalServerError, err.Error())
+		}
+
+		if ctx.Query("format") == "csv" || (ctx.Query("format") == "" && strings.Contains(ctx.Get(fiber.HeaderAccept), "text/csv")) {
+			var b strings.Builder
+			b.WriteString("timestamp,counter\n")
+			for _, p := range points {
+				b.WriteString(p.TimeStamp.Format(time.RFC3339) + "," + strconv.FormatFloat(p.Counter, 'f', -1, 64) + "\n")
+			}
+
+			ctx.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+			return ctx.SendString(b.String())
+		}
+
+		return ctx.JSON(historyResp{Meter: name, UnitCounter: m.Config.UnitCounter, Points: points})
+	}
+}
+
+// parseTime parses a time in RFC3339 format or unix time in seconds, an empty string returns the default time.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(i, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// parseDuration parses a duration e.g. 15m or seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if i, err := strconv.Atoi(s); err == nil {
+		return time.Duration(i) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
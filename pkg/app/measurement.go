@@ -1,11 +1,12 @@
 package app
 
 import (
-	"encoding/json"
 	"math"
 	"os"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/demand"
 	"s0counter/pkg/meter"
-	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -14,27 +15,62 @@ import (
 	"github.com/womat/tools"
 )
 
+// counterPrecision is the number of decimals of calculated counters, e.g. of virtual meters or counters with offset,
+// it removes the floating point noise of the calculation, e.g. 0.015000000000000003
+const counterPrecision = 9
+
 type SavedRecord struct {
-	Ticks     uint64    `yaml:"ticks"`     // current s0 ticks
-	Counter   float64   `yaml:"counter"`   // current meter counter (aktueller Zählerstand), eg kWh, l, m³ >> is not needed anymore, compatibility reason
-	TimeStamp time.Time `yaml:"timestamp"` // time of last s0 pulse
+	Ticks     uint64                            `yaml:"ticks"`             // current s0 ticks
+	Counter   float64                           `yaml:"counter"`           // current meter counter (aktueller Zählerstand), eg kWh, l, m³ >> is not needed anymore, compatibility reason
+	TimeStamp time.Time                         `yaml:"timestamp"`         // time of last s0 pulse
+	Tariffs   map[string]uint64                 `yaml:"tariffs,omitempty"` // s0 ticks per tariff
+	Periods   map[period.Period]period.Register `yaml:"periods,omitempty"` // consumption registers of the calendar periods
+
+	ExportTicks     uint64                            `yaml:"exportticks,omitempty"`     // current export s0 ticks of a bidirectional meter
+	ExportTimeStamp time.Time                         `yaml:"exporttimestamp,omitempty"` // time of last export s0 pulse of a bidirectional meter
+	ExportPeriods   map[period.Period]period.Register `yaml:"exportperiods,omitempty"`   // export registers of the calendar periods of a bidirectional meter
+
+	Offset    *float64         `yaml:"offset,omitempty"`    // reading offset, if it isn't defined, the configured offset is used
+	Exchanges []meter.Exchange `yaml:"exchanges,omitempty"` // history of the meter exchanges
+	Demand    *demand.Register `yaml:"demand,omitempty"`    // demand register, if it's enabled
 }
 type SaveMeters map[string]SavedRecord
 
 type MQTTRecord struct {
-	TimeStamp   time.Time // timestamp of last gauge calculation
-	Counter     float64   // current counter (aktueller Zählerstand), eg kWh, l, m³
-	UnitCounter string    // unit of current meter counter e.g. kWh, l, m³
-	Gauge       float64   // mass flow rate per time unit  (= counter/time(h)), e.g. kW, l/h, m³/h
-	UnitGauge   string    // unit of gauge, eg Wh, l/s, m³/h
+	TimeStamp   time.Time          // timestamp of last gauge calculation
+	Counter     float64            // current counter (aktueller Zählerstand), eg kWh, l, m³
+	UnitCounter string             // unit of current meter counter e.g. kWh, l, m³
+	Gauge       float64            // mass flow rate per time unit  (= counter/time(h)), e.g. kW, l/h, m³/h
+	UnitGauge   string             // unit of gauge, eg Wh, l/s, m³/h
+	Tariff      string             `json:",omitempty"` // active tariff
+	Tariffs     map[string]float64 `json:",omitempty"` // counter per tariff, eg kWh, l, m³
+	Consumption consumption        // consumption of the current and the previous periods, eg kWh, l, m³
+	Import      *direction         `json:",omitempty"` // import counter, gauge and consumption of a bidirectional meter
+	Export      *direction         `json:",omitempty"` // export counter, gauge and consumption of a bidirectional meter
+	Demand      *demandRecord      `json:",omitempty"` // average power of the current window and maximum demand
 }
 
 func (app *App) calcGauge() {
 	p := app.config.DataCollectionInterval
 	for range time.Tick(p) {
-		for n := range app.meters {
-			go app.sendMQTT(n)
+		for n, m := range app.meters {
+			now := app.now()
+			m.Lock()
+			app.updatePeriods(n, m, now)
+			app.updateDemand(m, now)
+			collectGauge(&m.S0, m.Config.Gauge, now)
+			collectGauge(&m.Export, m.Config.Gauge, now)
+			app.publishEvent(eventInterval, n, m, now)
+			due, _ := publishDue(m, now, false)
+			m.Unlock()
+
+			if due {
+				go app.sendMQTT(n)
+			}
 		}
+
+		app.evaluateAlarms(app.now())
+		app.checkWebhooks(app.now())
 	}
 }
 
@@ -44,31 +80,39 @@ func (app *App) sendMQTT(n string) {
 		return
 	}
 
+	now := app.now()
 	m.RLock()
 	defer m.RUnlock()
 
-	go func(t string, r MQTTRecord) {
-		debug.TraceLog.Printf("prepare mqtt message %v %v", t, r)
+	go func(name string, c config.MeterConfig, r MQTTRecord) {
+		debug.TraceLog.Printf("prepare mqtt message %v %v", c.MqttTopic, r)
 
-		b, err := json.MarshalIndent(r, "", "  ")
+		msgs, err := payloads(c, r)
 		if err != nil {
-			debug.ErrorLog.Printf("sendMQTT marshal: %v", err)
+			debug.ErrorLog.Printf("sendMQTT payload: %v", err)
 			return
 		}
 
-		app.mqtt.C <- mqtt.Message{
-			Qos:      0,
-			Retained: true,
-			Topic:    t,
-			Payload:  b,
+		for _, msg := range msgs {
+			app.mqtt.C <- msg
 		}
-	}(m.Config.MqttTopic,
+
+		if app.sparkplug != nil {
+			app.sparkplugData(name, r)
+		}
+	}(n, m.Config,
 		MQTTRecord{
-			TimeStamp:   time.Now(),
+			TimeStamp:   now,
 			Counter:     calcCounter(m),
 			UnitCounter: m.Config.UnitCounter,
-			Gauge:       calcGauge(m),
+			Gauge:       calcGauge(m, now),
 			UnitGauge:   m.Config.UnitGauge,
+			Tariff:      app.activeTariff(m, now),
+			Tariffs:     calcTariffs(m),
+			Consumption: app.calcConsumption(m, now),
+			Import:      app.calcImport(m, now),
+			Export:      app.calcExport(m, now),
+			Demand:      app.calcDemand(m, now),
 		})
 }
 
@@ -111,6 +155,22 @@ func (app *App) loadMeasurements() (err error) {
 			m.Lock()
 			m.S0.TimeStamp = loadedMeter.TimeStamp
 			m.S0.Tick = loadedMeter.Ticks
+			for t, ticks := range loadedMeter.Tariffs {
+				m.S0.Tariffs[t] = ticks
+			}
+			for p, r := range loadedMeter.Periods {
+				m.S0.Periods[p] = r
+			}
+			m.Export.TimeStamp = loadedMeter.ExportTimeStamp
+			m.Export.Tick = loadedMeter.ExportTicks
+			for p, r := range loadedMeter.ExportPeriods {
+				m.Export.Periods[p] = r
+			}
+			m.Offset = loadedMeter.Offset
+			m.Exchanges = loadedMeter.Exchanges
+			if loadedMeter.Demand != nil {
+				m.S0.Demand = *loadedMeter.Demand
+			}
 			m.Unlock()
 		}
 	}
@@ -130,8 +190,34 @@ func (app *App) saveMeasurements() error {
 	s := SaveMeters{}
 
 	for name, m := range app.meters {
+		// the counters of virtual meters are calculated from the referenced meters
+		if m.Config.Expr != nil {
+			continue
+		}
+
 		m.RLock()
-		s[name] = SavedRecord{Ticks: m.S0.Tick, Counter: calcCounter(m), TimeStamp: m.S0.TimeStamp}
+		tariffs := map[string]uint64{}
+		for t, ticks := range m.S0.Tariffs {
+			tariffs[t] = ticks
+		}
+		periods := map[period.Period]period.Register{}
+		for p, r := range m.S0.Periods {
+			periods[p] = r
+		}
+		var exportPeriods map[period.Period]period.Register
+		if len(m.Export.Periods) > 0 {
+			exportPeriods = map[period.Period]period.Register{}
+			for p, r := range m.Export.Periods {
+				exportPeriods[p] = r
+			}
+		}
+		var d *demand.Register
+		if m.Config.Demand.Window > 0 {
+			r := m.S0.Demand
+			d = &r
+		}
+		s[name] = SavedRecord{Ticks: m.S0.Tick, Counter: calcCounter(m), TimeStamp: m.S0.TimeStamp, Tariffs: tariffs, Periods: periods,
+			ExportTicks: m.Export.Tick, ExportTimeStamp: m.Export.TimeStamp, ExportPeriods: exportPeriods, Offset: m.Offset, Exchanges: append([]meter.Exchange(nil), m.Exchanges...), Demand: d}
 		m.RUnlock()
 	}
 
@@ -148,26 +234,49 @@ func (app *App) saveMeasurements() error {
 	return nil
 }
 
-func calcGauge(m *meter.Meter) (f float64) {
-	dt := m.S0.TimeStamp.Sub(m.S0.LastTimeStamp) // duration between last two ticks
-
-	// if duration between "now and last tick" is greater than the duration between "last two ticks"
-	// increase duration between "last two ticks" to duration between "now and the penultimate tick".
-	// this ensures that the value of the gauge value changes if no new pulse has been received
-	if time.Since(m.S0.TimeStamp) > dt {
-		dt = time.Since(m.S0.LastTimeStamp)
+// calcGauge returns the gauge of the meter at time now, the gauge of a bidirectional meter is the net gauge (import - export).
+func calcGauge(m *meter.Meter, now time.Time) (f float64) {
+	if m.Config.Expr != nil {
+		return toFixed(evalVirtual(m, func(o *meter.Meter) float64 { return calcGauge(o, now) }), m.Config.Precision)
 	}
 
-	// P = 3600 / (t * Cz) (Zählerkonstante in Imp/kWh)
-	f = 3600 / (dt.Seconds() * m.Config.CounterConstant) * m.Config.ScaleFactor
+	// the gauge of a bidirectional meter is negative, if more is exported than imported
+	f = registerGauge(m.S0, m.Config, now)
+	if m.Config.Bidirectional() {
+		f -= registerGauge(m.Export, m.Config, now)
+	}
 	return toFixed(f, m.Config.Precision)
 }
 
+// calcCounter returns the counter of the meter, the counter of a bidirectional meter is the net counter (import - export).
+// The reading offset is added, so the counter matches the reading of the physical meter.
 func calcCounter(m *meter.Meter) (f float64) {
-	f = float64(m.S0.Tick) / m.Config.CounterConstant
+	if m.Config.Expr != nil {
+		return toFixed(evalVirtual(m, calcCounter), counterPrecision)
+	}
+
+	f = tickCounter(m)
+	if offset := readingOffset(m); offset != 0 {
+		f = toFixed(f+offset, counterPrecision)
+	}
 	return f
 }
 
+// tickCounter returns the counter of the ticks without the reading offset.
+func tickCounter(m *meter.Meter) float64 {
+	// the difference of the ticks avoids the floating point noise of the difference of the counters
+	return (float64(m.S0.Tick) - float64(m.Export.Tick)) / m.Config.CounterConstant
+}
+
+// readingOffset returns the reading offset of the meter, it's the configured offset until the offset is changed,
+// e.g. by a meter exchange.
+func readingOffset(m *meter.Meter) float64 {
+	if m.Offset != nil {
+		return *m.Offset
+	}
+	return m.Config.Offset
+}
+
 func toFixed(num float64, precision int) float64 {
 	p := math.Pow(10, float64(precision))
 	return math.Round(num*p) / p
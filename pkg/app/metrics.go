@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/womat/debug"
+)
+
+// baseUnits are the prometheus base units of the meter units and the factor of the conversion, e.g. kWh to joules.
+var baseUnits = map[string]struct {
+	unit   string
+	factor float64
+}{
+	"Wh":  {"joules", 3.6e3},
+	"kWh": {"joules", 3.6e6},
+	"MWh": {"joules", 3.6e9},
+	"l":   {"cubic_meters", 1e-3},
+	"m³":  {"cubic_meters", 1},
+	"m3":  {"cubic_meters", 1},
+}
+
+// HandleMetrics returns the meters and the runtime stats in prometheus text format.
+// The reading of a meter can decrease (bidirectional and virtual meters, reading offset), so it's a gauge.
+// The import and export counters only count the pulses, they are counters in the prometheus base unit, e.g. joules.
+// output example:
+//  # HELP s0counter_reading Current reading of the meter in the unit of the meter.
+//  # TYPE s0counter_reading gauge
+//  s0counter_reading{meter="wallbox",unit="kWh"} 1234.567
+//  # HELP s0counter_import_total Imported energy or volume of the pulses in the base unit.
+//  # TYPE s0counter_import_total counter
+//  s0counter_import_total{meter="wallbox",unit="joules"} 4.4444412e+09
+func (app *App) HandleMetrics() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		debug.InfoLog.Print("web request metrics")
+
+		var b strings.Builder
+		metric := func(name, typ, help string, values map[string]float64) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+
+			labels := make([]string, 0, len(values))
+			for l := range values {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+
+			for _, l := range labels {
+				fmt.Fprintf(&b, "%s%s %v\n", name, l, values[l])
+			}
+		}
+
+		ticks := map[string]float64{}
+		readings := map[string]float64{}
+		gauges := map[string]float64{}
+		lastPulse := map[string]float64{}
+		rejected := map[string]float64{}
+		exportTicks := map[string]float64{}
+		imports := map[string]float64{}
+		exports := map[string]float64{}
+
+		now := app.now()
+		for n, m := range app.meters {
+			meterLabel := labels("meter", n)
+
+			m.RLock()
+			readings[labels("meter", n, "unit", m.Config.UnitCounter)] = calcCounter(m)
+			gauges[labels("meter", n, "unit", m.Config.UnitGauge)] = calcGauge(m, now)
+			// virtual meters don't count pulses
+			if m.Config.Expr == nil {
+				unit, factor := m.Config.UnitCounter, 1/m.Config.CounterConstant
+				if b, ok := baseUnits[unit]; ok {
+					unit, factor = b.unit, b.factor*factor
+				}
+
+				ticks[meterLabel] = float64(m.S0.Tick)
+				imports[labels("meter", n, "unit", unit)] = float64(m.S0.Tick) * factor
+				if !m.S0.TimeStamp.IsZero() {
+					lastPulse[meterLabel] = float64(m.S0.TimeStamp.UnixNano()) / 1e9
+				}
+				rejected[meterLabel] = float64(m.S0.Rejected + m.Export.Rejected)
+
+				// the reading of a bidirectional meter is the net counter, the directions are exported separately
+				if m.Config.Bidirectional() {
+					exportTicks[meterLabel] = float64(m.Export.Tick)
+					exports[labels("meter", n, "unit", unit)] = float64(m.Export.Tick) * factor
+				}
+			}
+			m.RUnlock()
+		}
+
+		metric("s0counter_ticks_total", "counter", "S0 pulses counted by the meter.", ticks)
+		metric("s0counter_reading", "gauge", "Current reading of the meter in the unit of the meter, including the offset.", readings)
+		metric("s0counter_gauge", "gauge", "Current gauge of the meter in the unit of the meter.", gauges)
+		metric("s0counter_last_pulse_timestamp_seconds", "gauge", "Time of the last S0 pulse of the meter.", lastPulse)
+		metric("s0counter_rejected_pulses_total", "counter", "S0 pulses rejected by the plausibility filter.", rejected)
+		metric("s0counter_export_ticks_total", "counter", "Export S0 pulses counted by the bidirectional meter.", exportTicks)
+		metric("s0counter_import_total", "counter", "Imported energy or volume of the pulses in the base unit.", imports)
+		metric("s0counter_export_total", "counter", "Exported energy or volume of the pulses of the bidirectional meter in the base unit.", exports)
+
+		published, failed := app.mqtt.Stats()
+		metric("s0counter_mqtt_published_total", "counter", "MQTT messages published successfully.", map[string]float64{"": float64(published)})
+		metric("s0counter_mqtt_failed_total", "counter", "MQTT messages which couldn't be published.", map[string]float64{"": float64(failed)})
+		metric("s0counter_mqtt_queue_depth", "gauge", "MQTT messages queued until the broker is connected again.", map[string]float64{"": float64(app.mqtt.QueueDepth())})
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		metric("s0counter_goroutines", "gauge", "Number of goroutines.", map[string]float64{"": float64(runtime.NumGoroutine())})
+		metric("s0counter_heap_allocated_bytes", "gauge", "Allocated heap memory.", map[string]float64{"": float64(mem.Alloc)})
+		metric("s0counter_sys_memory_bytes", "gauge", "Memory obtained from the OS.", map[string]float64{"": float64(mem.Sys)})
+		metric("s0counter_build_info", "gauge", "Version of s0counter.", map[string]float64{labels("version", VERSION, "goversion", runtime.Version()): 1})
+
+		ctx.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return ctx.SendString(b.String())
+	}
+}
+
+// labels returns the prometheus labels of the name value pairs, e.g. {meter="wallbox",unit="kWh"}
+func labels(kv ...string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var l []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		l = append(l, kv[i]+`="`+r.Replace(kv[i+1])+`"`)
+	}
+	return "{" + strings.Join(l, ",") + "}"
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"io"
+	"net/http/httptest"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMetrics(t *testing.T) {
+	app := &App{web: fiber.New(), mqtt: mqtt.New(), meters: map[string]*meter.Meter{
+		"grid": {
+			Config: config.MeterConfig{Type: "bidirectional", ExportGpio: 18, CounterConstant: 1000, UnitCounter: "kWh", Offset: 100},
+			S0:     meter.S0{Tick: 5000},
+			Export: meter.S0{Tick: 7000},
+		},
+		"water": {
+			Config: config.MeterConfig{CounterConstant: 1, UnitCounter: "l"},
+			S0:     meter.S0{Tick: 250},
+		},
+	}}
+	app.web.Get("/metrics", app.HandleMetrics())
+
+	resp, err := app.web.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(b)
+
+	for _, want := range []string{
+		// the net reading including the offset decreases with the export, it's a gauge
+		"# TYPE s0counter_reading gauge\n",
+		`s0counter_reading{meter="grid",unit="kWh"} 98` + "\n",
+		`s0counter_reading{meter="water",unit="l"} 250` + "\n",
+		// the pulses in base units
+		"# TYPE s0counter_import_total counter\n",
+		`s0counter_import_total{meter="grid",unit="joules"} 1.8e+07` + "\n",
+		`s0counter_import_total{meter="water",unit="cubic_meters"} 0.25` + "\n",
+		"# TYPE s0counter_export_total counter\n",
+		`s0counter_export_total{meter="grid",unit="joules"} 2.52e+07` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+	if strings.Contains(body, `s0counter_export_total{meter="water"`) {
+		t.Error("export counter of a meter, which isn't bidirectional")
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/mqtt"
+	"strconv"
+	"time"
+)
+
+// payloads returns the mqtt messages of the record in the payload format of the meter.
+//  json (default): MQTTRecord as indented json to topic <mqtttopic>
+//  compact: MQTTRecord as compact json with the configured field names to topic <mqtttopic>
+//  plain: each value as plain text to the subtopics <mqtttopic>/counter, <mqtttopic>/gauge, <mqtttopic>/import/counter, ...
+//  template: output of the configured go text/template of MQTTRecord to topic <mqtttopic>
+// If the meter has no mqtt topic, no message is returned.
+func payloads(c config.MeterConfig, r MQTTRecord) ([]mqtt.Message, error) {
+	if c.MqttTopic == "" {
+		return nil, nil
+	}
+
+	msg := func(topic string, payload []byte) mqtt.Message {
+		return mqtt.Message{Qos: c.Payload.Qos, Retained: c.Payload.Retained, Topic: topic, Payload: payload, TimeStamp: r.TimeStamp}
+	}
+
+	switch c.Payload.Format {
+	case "compact":
+		b, err := compactPayload(r, c.Payload.Fields)
+		if err != nil {
+			return nil, err
+		}
+		return []mqtt.Message{msg(c.MqttTopic, b)}, nil
+
+	case "plain":
+		f := func(v float64) []byte { return []byte(strconv.FormatFloat(v, 'f', -1, 64)) }
+
+		m := []mqtt.Message{
+			msg(c.MqttTopic+"/counter", f(r.Counter)),
+			msg(c.MqttTopic+"/gauge", f(r.Gauge)),
+			msg(c.MqttTopic+"/unitcounter", []byte(r.UnitCounter)),
+			msg(c.MqttTopic+"/unitgauge", []byte(r.UnitGauge)),
+			msg(c.MqttTopic+"/timestamp", []byte(r.TimeStamp.Format(time.RFC3339))),
+		}
+		if r.Tariff != "" {
+			m = append(m, msg(c.MqttTopic+"/tariff", []byte(r.Tariff)))
+		}
+		if r.Import != nil && r.Export != nil {
+			m = append(m,
+				msg(c.MqttTopic+"/import/counter", f(r.Import.Counter)),
+				msg(c.MqttTopic+"/import/gauge", f(r.Import.Gauge)),
+				msg(c.MqttTopic+"/export/counter", f(r.Export.Counter)),
+				msg(c.MqttTopic+"/export/gauge", f(r.Export.Gauge)),
+			)
+		}
+		if r.Demand != nil {
+			m = append(m,
+				msg(c.MqttTopic+"/demand/current", f(r.Demand.Current)),
+				msg(c.MqttTopic+"/demand/today", f(r.Demand.Today.Value)),
+				msg(c.MqttTopic+"/demand/thismonth", f(r.Demand.ThisMonth.Value)),
+			)
+			if r.Demand.Projected != nil {
+				m = append(m, msg(c.MqttTopic+"/demand/projected", f(*r.Demand.Projected)))
+			}
+		}
+		return m, nil
+
+	case "template":
+		var b bytes.Buffer
+		if err := c.Payload.Template.Execute(&b, r); err != nil {
+			return nil, err
+		}
+		return []mqtt.Message{msg(c.MqttTopic, b.Bytes())}, nil
+
+	default:
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return []mqtt.Message{msg(c.MqttTopic, b)}, nil
+	}
+}
+
+// compactPayload returns the record as compact json, the fields are renamed by the field names.
+// If field names are defined, only the renamed fields are included, e.g. {"Counter": "c", "Gauge": "g"} >> {"c":1.2,"g":3}
+func compactPayload(r MQTTRecord, fields map[string]string) ([]byte, error) {
+	if len(fields) == 0 {
+		return json.Marshal(r)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+
+	compact := map[string]json.RawMessage{}
+	for field, name := range fields {
+		if v, ok := values[field]; ok {
+			compact[name] = v
+		}
+	}
+	return json.Marshal(compact)
+}
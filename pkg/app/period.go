@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"time"
+
+	"github.com/womat/debug"
+)
+
+// consumption contains the consumption of the current and the previous periods, eg kWh, l, m³
+type consumption struct {
+	ThisHour  float64
+	LastHour  float64
+	Today     float64
+	Yesterday float64
+	ThisWeek  float64
+	LastWeek  float64
+	ThisMonth float64
+	LastMonth float64
+	ThisYear  float64
+	LastYear  float64
+}
+
+// PeriodRecord is the mqtt message of a closed period, it's sent to topic <mqtttopic>/period
+type PeriodRecord struct {
+	Period      string    // hour, day, week, month, year
+	Start       time.Time // start of the closed period
+	End         time.Time // end of the closed period
+	Consumption float64   // consumption of the closed period, eg kWh, l, m³, the net consumption (import - export) of a bidirectional meter
+	Import      *float64  `json:",omitempty"` // import of the closed period of a bidirectional meter
+	Export      *float64  `json:",omitempty"` // export of the closed period of a bidirectional meter
+	UnitCounter string    // unit of consumption e.g. kWh, l, m³
+}
+
+// updatePeriods closes the periods of the meter, which have been ended at time t.
+// The meter must be locked by the caller.
+// Virtual meters have no registers, their consumption is calculated from the referenced meters.
+// Bidirectional meters have export registers additionally, the period is closed by both registers at the same time.
+func (app *App) updatePeriods(name string, m *meter.Meter, t time.Time) {
+	if m.Config.Expr != nil {
+		return
+	}
+
+	t = t.In(app.config.Location)
+
+	for _, p := range period.All {
+		r := m.S0.Periods[p]
+		closed := r.Update(p, t, m.S0.Tick)
+		m.S0.Periods[p] = r
+
+		var e period.Register
+		if m.Config.Bidirectional() {
+			e = m.Export.Periods[p]
+			e.Update(p, t, m.Export.Tick)
+			m.Export.Periods[p] = e
+		}
+
+		if closed {
+			app.sendPeriod(name, m, p, r, e)
+		}
+	}
+}
+
+// sendPeriod sends the consumption of the closed period to the mqtt broker and the webhooks.
+// The export register e is only used by bidirectional meters.
+func (app *App) sendPeriod(name string, m *meter.Meter, p period.Period, r, e period.Register) {
+	rec := PeriodRecord{
+		Period:      string(p),
+		Start:       r.LastStart,
+		End:         r.Start,
+		Consumption: float64(r.LastTicks) / m.Config.CounterConstant,
+		UnitCounter: m.Config.UnitCounter,
+	}
+	if m.Config.Bidirectional() {
+		imp, exp := rec.Consumption, float64(e.LastTicks)/m.Config.CounterConstant
+		rec.Consumption = (float64(r.LastTicks) - float64(e.LastTicks)) / m.Config.CounterConstant
+		rec.Import, rec.Export = &imp, &exp
+	}
+	app.notify(WebhookEvent{Event: webhookPeriod, TimeStamp: r.Start, Meter: name, Data: rec})
+
+	if m.Config.MqttTopic == "" {
+		return
+	}
+
+	go func(t string, rec PeriodRecord) {
+		debug.TraceLog.Printf("prepare mqtt message %v %v", t, rec)
+
+		b, err := json.MarshalIndent(rec, "", "  ")
+		if err != nil {
+			debug.ErrorLog.Printf("sendPeriod marshal: %v", err)
+			return
+		}
+
+		app.mqtt.C <- mqtt.Message{
+			Qos:       0,
+			Retained:  false,
+			Topic:     t,
+			Payload:   b,
+			TimeStamp: rec.End,
+		}
+	}(m.Config.MqttTopic+"/period", rec)
+}
+
+// calcConsumption returns the consumption of the current and the previous periods at time t.
+// The consumption of a bidirectional meter is the net consumption (import - export) like its counter.
+// The registers of the meter aren't changed, so a read lock of the meter is sufficient.
+func (app *App) calcConsumption(m *meter.Meter, t time.Time) consumption {
+	if m.Config.Expr != nil {
+		return app.calcVirtualConsumption(m, t)
+	}
+
+	if m.Config.Bidirectional() {
+		return app.registerConsumption(m.S0, &m.Export, m.Config.CounterConstant, t)
+	}
+	return app.registerConsumption(m.S0, nil, m.Config.CounterConstant, t)
+}
+
+// registerConsumption returns the consumption of the period registers of r at time t,
+// if the register e isn't nil, its consumption is subtracted.
+func (app *App) registerConsumption(r meter.S0, e *meter.S0, counterConstant float64, t time.Time) (c consumption) {
+	t = t.In(app.config.Location)
+
+	ticks := func(s meter.S0, p period.Period) (float64, float64) {
+		reg := s.Periods[p]
+		reg.Update(p, t, s.Tick)
+		return float64(reg.Ticks(s.Tick)), float64(reg.LastTicks)
+	}
+
+	get := func(p period.Period) (float64, float64) {
+		this, last := ticks(r, p)
+		if e != nil {
+			exportThis, exportLast := ticks(*e, p)
+			this, last = this-exportThis, last-exportLast
+		}
+		return this / counterConstant, last / counterConstant
+	}
+
+	c.ThisHour, c.LastHour = get(period.Hour)
+	c.Today, c.Yesterday = get(period.Day)
+	c.ThisWeek, c.LastWeek = get(period.Week)
+	c.ThisMonth, c.LastMonth = get(period.Month)
+	c.ThisYear, c.LastYear = get(period.Year)
+	return
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"encoding/json"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/expression"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"testing"
+	"time"
+)
+
+func TestBidirectionalPeriods(t *testing.T) {
+	expr, err := expression.Parse("pv + grid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.NewConfig()
+	c.Location = time.UTC
+	grid := &meter.Meter{
+		Config: config.MeterConfig{Type: "bidirectional", ExportGpio: 18, CounterConstant: 1000, UnitCounter: "kWh", MqttTopic: "test/grid"},
+		S0:     meter.S0{Tick: 1000, Periods: map[period.Period]period.Register{}},
+		Export: meter.S0{Tick: 500, Periods: map[period.Period]period.Register{}},
+	}
+	pv := &meter.Meter{
+		Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh"},
+		S0:     meter.S0{Tick: 200, Periods: map[period.Period]period.Register{}},
+	}
+	house := &meter.Meter{
+		Config:   config.MeterConfig{CounterConstant: 1, UnitCounter: "kWh", Expr: expr},
+		Operands: map[string]*meter.Meter{"pv": pv, "grid": grid},
+	}
+	app := &App{config: c, mqtt: mqtt.New(), meters: map[string]*meter.Meter{"grid": grid, "pv": pv, "house": house}}
+
+	// the registers start at 10:15, 2 kWh are imported, 4 kWh exported and 5 kWh produced within the hour
+	start := time.Date(2026, 3, 10, 10, 15, 0, 0, time.UTC)
+	app.updatePeriods("grid", grid, start)
+	app.updatePeriods("pv", pv, start)
+	grid.S0.Tick, grid.Export.Tick, pv.S0.Tick = 3000, 4500, 5200
+
+	now := start.Add(30 * time.Minute)
+	if got := app.calcConsumption(grid, now).ThisHour; got != -2 {
+		t.Errorf("net consumption %v kWh, want -2 kWh", got)
+	}
+	if got := app.calcImport(grid, now).Consumption.ThisHour; got != 2 {
+		t.Errorf("import consumption %v kWh, want 2 kWh", got)
+	}
+	if got := app.calcExport(grid, now).Consumption.ThisHour; got != 4 {
+		t.Errorf("export consumption %v kWh, want 4 kWh", got)
+	}
+	// the virtual meter uses the net consumption of the bidirectional operand like its counter
+	if got := app.calcConsumption(house, now).ThisHour; got != 3 {
+		t.Errorf("virtual consumption %v kWh, want 3 kWh", got)
+	}
+
+	// the hour is closed by the import and the export register
+	end := start.Add(time.Hour)
+	app.updatePeriods("grid", grid, end)
+
+	select {
+	case msg := <-app.mqtt.C:
+		var rec PeriodRecord
+		if err = json.Unmarshal(msg.Payload, &rec); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Topic != "test/grid/period" || rec.Period != string(period.Hour) {
+			t.Fatalf("period message %v %v, want test/grid/period hour", msg.Topic, rec.Period)
+		}
+		if rec.Consumption != -2 || rec.Import == nil || *rec.Import != 2 || rec.Export == nil || *rec.Export != 4 {
+			t.Errorf("closed hour %v kWh, import %v, export %v, want -2 kWh, import 2, export 4", rec.Consumption, rec.Import, rec.Export)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no period message")
+	}
+
+	if got := app.calcConsumption(grid, end).LastHour; got != -2 {
+		t.Errorf("net consumption of the last hour %v kWh, want -2 kWh", got)
+	}
+	if got := app.calcExport(grid, end).Consumption.ThisHour; got != 0 {
+		t.Errorf("export consumption of the new hour %v kWh, want 0 kWh", got)
+	}
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"math"
+	"s0counter/pkg/meter"
+	"time"
+)
+
+// publishDue returns true, if the values of the meter have to be published according to the publish policy.
+//  interval: every interval
+//  pulse: on every pulse, but at most every mininterval
+//  counter: every data collection interval, if the counter has been changed
+//  gauge: every data collection interval, if the gauge has been changed more than the deadband
+// Except policy interval, the values are published at least every heartbeat (if defined).
+// The pulse flag signals a call on an accepted pulse, otherwise it's called every data collection interval.
+// The meter must be locked by the caller, if the values are due, the published values are stored.
+// A pulse within the mininterval of policy pulse returns the wait time until a trailing publish is due,
+// only the first dropped pulse after a publish returns a wait time.
+func publishDue(m *meter.Meter, now time.Time, pulse bool) (due bool, wait time.Duration) {
+	c := m.Config.Publish
+	last := m.Published
+	since := now.Sub(last.TimeStamp)
+
+	switch c.Policy {
+	case "pulse":
+		due = pulse && since >= c.MinInterval
+		if pulse && !due && !last.Trailing {
+			m.Published.Trailing = true
+			wait = c.MinInterval - since
+		}
+	case "counter":
+		due = !pulse && calcCounter(m) != last.Counter
+	case "gauge":
+		due = !pulse && gaugeChanged(last.Gauge, calcGauge(m, now), c.Deadband, c.DeadbandRelative)
+	default:
+		// the tolerance of a tenth of the interval compensates the jitter of the data collection ticker
+		due = !pulse && since >= c.Interval-c.Interval/10
+	}
+
+	if c.Policy != "interval" && !due && !pulse && c.Heartbeat > 0 && since >= c.Heartbeat {
+		due = true
+	}
+
+	if due {
+		m.Published = meter.Published{TimeStamp: now, Counter: calcCounter(m), Gauge: calcGauge(m, now)}
+	}
+	return
+}
+
+// schedulePublish publishes the values of the meter after the wait time, if they haven't been published in the meantime.
+func (app *App) schedulePublish(name string, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+
+	time.AfterFunc(wait, func() {
+		m := app.meters[name]
+		m.Lock()
+		if !m.Published.Trailing {
+			m.Unlock()
+			return
+		}
+
+		m.Published.Trailing = false
+		due, wait := publishDue(m, app.now(), true)
+		m.Unlock()
+
+		if due {
+			app.sendMQTT(name)
+		}
+		app.schedulePublish(name, wait)
+	})
+}
+
+// gaugeChanged returns true, if the gauge has been changed more than the absolute or the relative deadband (%).
+// Without deadbands, each change is relevant.
+func gaugeChanged(last, gauge, deadband, relative float64) bool {
+	d := math.Abs(gauge - last)
+
+	switch {
+	case deadband == 0 && relative == 0:
+		return d > 0
+	case deadband > 0 && d > deadband:
+		return true
+	case relative > 0 && d > math.Abs(last)*relative/100:
+		return true
+	default:
+		return false
+	}
+}
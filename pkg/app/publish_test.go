@@ -0,0 +1,70 @@
+package app
+
+import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/period"
+	"testing"
+	"time"
+)
+
+func TestPublishTrailing(t *testing.T) {
+	c := config.NewConfig()
+	c.Location = time.UTC
+	minInterval := 200 * time.Millisecond
+	m := &meter.Meter{
+		Config: config.MeterConfig{CounterConstant: 1000, UnitCounter: "kWh", MqttTopic: "test/power",
+			Publish: config.PublishConfig{Policy: "pulse", MinInterval: minInterval}},
+		S0: meter.S0{Tariffs: map[string]uint64{}, Periods: map[period.Period]period.Register{}},
+	}
+	app := &App{config: c, mqtt: mqtt.New(), meters: map[string]*meter.Meter{"power": m}}
+
+	pulse := func() (bool, time.Duration) {
+		m.Lock()
+		defer m.Unlock()
+
+		m.S0.Tick++
+		return publishDue(m, time.Now(), true)
+	}
+
+	start := time.Now()
+	if due, wait := pulse(); !due || wait != 0 {
+		t.Fatalf("first pulse: due %v, wait %v, want due without wait", due, wait)
+	}
+
+	// the first pulse within the mininterval schedules the trailing publish, the next ones don't
+	due, wait := pulse()
+	if due || wait <= 0 || wait > minInterval {
+		t.Fatalf("pulse within the mininterval: due %v, wait %v, want a wait up to %v", due, wait, minInterval)
+	}
+	app.schedulePublish("power", wait)
+	if due, wait = pulse(); due || wait != 0 {
+		t.Errorf("next pulse within the mininterval: due %v, wait %v, want no publish", due, wait)
+	}
+
+	select {
+	case msg := <-app.mqtt.C:
+		if d := time.Since(start); d < minInterval {
+			t.Errorf("trailing publish after %v, want at least %v", d, minInterval)
+		}
+		if msg.Topic != "test/power" {
+			t.Errorf("topic %v, want test/power", msg.Topic)
+		}
+	case <-time.After(5 * minInterval):
+		t.Fatal("no trailing publish")
+	}
+
+	m.RLock()
+	published := m.Published
+	m.RUnlock()
+	if published.Counter != 0.003 || published.Trailing {
+		t.Errorf("published counter %v, trailing %v, want 0.003 kWh without trailing publish", published.Counter, published.Trailing)
+	}
+
+	select {
+	case msg := <-app.mqtt.C:
+		t.Errorf("unexpected message %v", msg.Topic)
+	case <-time.After(2 * minInterval):
+	}
+}
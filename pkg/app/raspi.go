@@ -1,12 +1,57 @@
 package app
 
 import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/pulselog"
 	"s0counter/pkg/raspberry"
 	"time"
 
 	"github.com/womat/debug"
 )
 
+// openGPIO opens the gpio driver defined in the configuration.
+func openGPIO(c *config.Config) (raspberry.GPIO, error) {
+	switch c.GPIO.Driver {
+	case "gpiod":
+		return raspberry.OpenGpiod(c.GPIO.Chip)
+	case "sim":
+		profiles := map[int]raspberry.SimProfile{}
+		for _, m := range c.Meter {
+			if m.Expr != nil {
+				continue
+			}
+			profiles[m.Gpio] = simProfile(m.Simulation)
+			if m.Bidirectional() {
+				profiles[m.ExportGpio] = simProfile(m.ExportSimulation)
+			}
+		}
+		return raspberry.OpenSim(profiles)
+	case "replay":
+		return raspberry.OpenReplay(c.GPIO.ReplayFile, c.GPIO.ReplaySpeed)
+	default:
+		return raspberry.Open()
+	}
+}
+
+// now returns the time of the gpio driver, e.g. the replay clock, otherwise the system time.
+func (app *App) now() time.Time {
+	if c, ok := app.gpio.(raspberry.Clock); ok {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// simProfile returns the pulse profile of the simulated S0 input.
+func simProfile(s config.SimulationConfig) raspberry.SimProfile {
+	return raspberry.SimProfile{
+		Profile:    s.Profile,
+		Rate:       s.Rate,
+		Sequence:   s.Sequence,
+		PulseWidth: s.PulseWidth,
+		Bounces:    s.Bounces,
+	}
+}
+
 // testPinEmu emulate ticks on gpio pin, only for testing in windows mode
 func testPinEmu(p raspberry.Pin) {
 	for range time.Tick(time.Duration(p.Pin()/2) * time.Second) {
@@ -14,22 +59,65 @@ func testPinEmu(p raspberry.Pin) {
 	}
 }
 
-func (app *App) handler(p raspberry.Pin) {
+// edgeLevel returns the level of the pin after the last edge. It's derived from the direction of the edge, if the driver knows it.
+// Otherwise the pin is read, a spike shorter than the latency of the handler reads the idle level on both edges.
+func edgeLevel(p raspberry.Pin) bool {
+	if er, ok := p.(raspberry.EdgeReader); ok {
+		return er.LastEdge() == raspberry.EdgeRising
+	}
+	return p.Read()
+}
+
+// handler counts a pulse of the S0 input p of the meter, export selects the export register of a bidirectional meter.
+// Each input has its own handler, so the same gpio number can be used on different chips.
+func (app *App) handler(name string, export bool, p raspberry.Pin) {
+	m := app.meters[name]
+	r := &m.S0
+	if export {
+		r = &m.Export
+	}
 	pin := p.Pin()
 
-	for _, m := range app.meters {
-		// find the measuring device based on the pin configuration
-		if m.Config.Gpio == pin {
-			// add current counter & set time stamp
-			debug.TraceLog.Printf("receive an impulse on pin: %v", pin)
-
-			t := time.Now()
-			m.Lock()
-			m.S0.LastTimeStamp = m.S0.TimeStamp
-			m.S0.TimeStamp = t
-			m.S0.Tick++
-			m.Unlock()
-			return
+	// add current counter & set time stamp
+	debug.TraceLog.Printf("receive an impulse on pin: %v", pin)
+
+	t := app.now()
+	if et, ok := p.(raspberry.EdgeTimer); ok {
+		// use the exact time of the edge, if the driver supports it
+		t = et.EdgeTime()
+	}
+	active := edgeLevel(p) == (m.Config.ActiveLevel == "high")
+
+	m.Lock()
+	// a replayed pulse is an already filtered pulse of the recording
+	if app.config.GPIO.Driver != "replay" && !filter(m, r, pin, t, active) {
+		m.Unlock()
+		return
+	}
+
+	// close the periods before the pulse is counted, so the pulse is counted in the new period
+	app.updatePeriods(name, m, t)
+	app.updateDemand(m, t)
+	r.LastTimeStamp = r.TimeStamp
+	r.TimeStamp = t
+	r.Tick++
+	updateGauge(r, m.Config.Gauge, t)
+	// the tariff registers count the import of a bidirectional meter
+	if tariff := app.activeTariff(m, t); tariff != "" && !export {
+		m.S0.Tariffs[tariff]++
+	}
+	app.publishEvent(eventPulse, name, m, t)
+	due, wait := publishDue(m, app.now(), true)
+	m.Unlock()
+
+	if due {
+		go app.sendMQTT(name)
+	}
+	app.schedulePublish(name, wait)
+
+	if app.recorder != nil {
+		if err := app.recorder.Write(pulselog.Record{Meter: name, Chip: m.Config.Chip, Pin: pin, TimeStamp: t}); err != nil {
+			debug.ErrorLog.Printf("can't record pulse: %v", err)
 		}
 	}
 }
@@ -14,4 +14,23 @@ func (app *App) initDefaultRoutes() {
 	if app.config.Webserver.Webservices["currentdata"] {
 		api.Get("/currentdata", app.HandleCurrentData())
 	}
+	if app.config.Webserver.Webservices["metrics"] {
+		api.Get("/metrics", app.HandleMetrics())
+	}
+	if app.config.Webserver.Webservices["stream"] {
+		api.Get("/stream", app.HandleStream())
+	}
+	if app.config.Webserver.Webservices["history"] && app.history != nil {
+		api.Get("/history/:meter", app.HandleHistory())
+	}
+	if app.config.Webserver.Webservices["alarms"] {
+		api.Get("/alarms", app.HandleAlarms())
+		api.Get("/alarms/history", app.HandleAlarmHistory())
+	}
+	if app.config.Webserver.Webservices["admin"] {
+		admin := api.Group("/admin", app.adminAuth())
+		admin.Get("/:meter", app.HandleAdminMeter())
+		admin.Put("/:meter/offset", app.HandleAdminOffset())
+		admin.Post("/:meter/exchange", app.HandleAdminExchange())
+	}
 }
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"os"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/sparkplug"
+	"sort"
+	"sync"
+
+	"github.com/womat/debug"
+	"github.com/womat/tools"
+	"gopkg.in/yaml.v2"
+)
+
+// sparkplugNode is the sparkplug b edge node of s0counter, each meter is a device of the node.
+// The node uses its own connection to the broker, because the will of the connection is the NDEATH.
+type sparkplugNode struct {
+	sync.Mutex
+	handler *mqtt.Handler
+	// bdSeq is the birth/death sequence number of the connection, it's incremented before every (re)connect
+	bdSeq uint64
+	// seq is the sequence number of the messages, the NBIRTH has the sequence number 0
+	seq uint64
+	// born is set after the NBIRTH has been published
+	born bool
+}
+
+// savedSparkplug is the content of the sparkplug file.
+type savedSparkplug struct {
+	BdSeq uint64 `yaml:"bdseq"` // bdSeq of the last connection
+}
+
+// initSparkplug connects the sparkplug edge node to the broker.
+// The NBIRTH and the DBIRTH of all meters are published after every (re)connect and on a rebirth command.
+func (app *App) initSparkplug() error {
+	c := app.config.MQTT.Sparkplug
+	if !c.Enabled {
+		return nil
+	}
+
+	// the first connection gets the bdSeq 0 or the successor of the saved bdSeq
+	bdSeq, err := loadBdSeq(c.File)
+	if err != nil {
+		return err
+	}
+	app.sparkplug = &sparkplugNode{handler: mqtt.New(), bdSeq: bdSeq}
+	app.sparkplug.handler.SetWillFunc(app.sparkplugWill)
+	app.sparkplug.handler.OnConnect(app.sparkplugBirth)
+
+	if err := app.sparkplug.handler.Subscribe(sparkplug.Topic(c.GroupID, sparkplug.NCmd, c.EdgeNodeID, ""), 0, app.sparkplugCommand); err != nil {
+		return err
+	}
+
+	return app.sparkplug.handler.Connect(app.mqttOptions(c.ClientID))
+}
+
+// sparkplugWill increments the bdSeq and returns the NDEATH of the new connection.
+func (app *App) sparkplugWill() mqtt.Message {
+	n := app.sparkplug
+	n.Lock()
+	defer n.Unlock()
+
+	n.bdSeq = (n.bdSeq + 1) % 256
+	n.born = false
+	if err := saveBdSeq(app.config.MQTT.Sparkplug.File, n.bdSeq); err != nil {
+		debug.ErrorLog.Printf("can't save sparkplug bdSeq: %v", err)
+	}
+	return app.sparkplugDeathMessage()
+}
+
+// loadBdSeq returns the bdSeq of the last connection of the sparkplug file.
+// If the file isn't defined or doesn't exist, it returns 255, so the next connection gets the bdSeq 0.
+func loadBdSeq(fileName string) (uint64, error) {
+	if fileName == "" || !tools.FileExists(fileName) {
+		return 255, nil
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	var s savedSparkplug
+	if err = yaml.Unmarshal(data, &s); err != nil {
+		return 0, err
+	}
+	return s.BdSeq % 256, nil
+}
+
+// saveBdSeq saves the bdSeq of the current connection to the sparkplug file, if it's defined.
+func saveBdSeq(fileName string, bdSeq uint64) error {
+	if fileName == "" {
+		return nil
+	}
+
+	data, err := yaml.Marshal(&savedSparkplug{BdSeq: bdSeq})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0o600)
+}
+
+// sparkplugDeath publishes the NDEATH on a graceful shutdown, before the connection is closed.
+func (app *App) sparkplugDeath() {
+	n := app.sparkplug
+	n.Lock()
+	defer n.Unlock()
+
+	if err := n.handler.Publish(app.sparkplugDeathMessage()); err != nil {
+		debug.ErrorLog.Printf("can't publish sparkplug NDEATH: %v", err)
+	}
+	n.born = false
+}
+
+// sparkplugDeathMessage returns the NDEATH with the bdSeq of the connection, the node must be locked.
+func (app *App) sparkplugDeathMessage() mqtt.Message {
+	c := app.config.MQTT.Sparkplug
+	p := sparkplug.Payload{
+		TimeStamp: app.now(),
+		Metrics:   []sparkplug.Metric{{Name: sparkplug.MetricBdSeq, DataType: sparkplug.UInt64, Value: app.sparkplug.bdSeq}},
+	}
+
+	return mqtt.Message{Topic: sparkplug.Topic(c.GroupID, sparkplug.NDeath, c.EdgeNodeID, ""), Payload: p.Marshal(), Qos: 1}
+}
+
+// sparkplugBirth publishes the NBIRTH of the node and the DBIRTH of each meter.
+func (app *App) sparkplugBirth() {
+	c := app.config.MQTT.Sparkplug
+	n := app.sparkplug
+	n.Lock()
+	defer n.Unlock()
+
+	now := app.now()
+	n.seq = 0
+	nbirth := sparkplug.Payload{
+		TimeStamp: now,
+		Seq:       sparkplug.Seq(n.seq),
+		Metrics: []sparkplug.Metric{
+			{Name: sparkplug.MetricBdSeq, DataType: sparkplug.UInt64, Value: n.bdSeq},
+			{Name: sparkplug.MetricRebirth, DataType: sparkplug.Boolean, Value: false},
+		},
+	}
+	if err := n.handler.Publish(mqtt.Message{Topic: sparkplug.Topic(c.GroupID, sparkplug.NBirth, c.EdgeNodeID, ""), Payload: nbirth.Marshal()}); err != nil {
+		debug.ErrorLog.Printf("can't publish sparkplug NBIRTH: %v", err)
+		return
+	}
+	n.born = true
+
+	names := make([]string, 0, len(app.meters))
+	for name := range app.meters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := app.meters[name]
+		m.RLock()
+		r := MQTTRecord{
+			TimeStamp:   now,
+			Counter:     calcCounter(m),
+			UnitCounter: m.Config.UnitCounter,
+			Gauge:       calcGauge(m, now),
+			UnitGauge:   m.Config.UnitGauge,
+			Import:      app.calcImport(m, now),
+			Export:      app.calcExport(m, now),
+		}
+		m.RUnlock()
+
+		app.sparkplugPublish(sparkplug.DBirth, name, r)
+	}
+}
+
+// sparkplugData publishes the DDATA of the meter.
+func (app *App) sparkplugData(name string, r MQTTRecord) {
+	n := app.sparkplug
+	n.Lock()
+	defer n.Unlock()
+
+	// the values are published with the DBIRTH after the next connect
+	if !n.born {
+		return
+	}
+
+	app.sparkplugPublish(sparkplug.DData, name, r)
+}
+
+// sparkplugPublish publishes the counter and the gauge of the meter with the next sequence number, the node must be locked.
+// The metric definitions of a DBIRTH contain the units as engineering unit property.
+// Bidirectional meters have the metrics Import/Counter, Import/Gauge, Export/Counter and Export/Gauge additionally.
+func (app *App) sparkplugPublish(messageType, name string, r MQTTRecord) {
+	c := app.config.MQTT.Sparkplug
+	n := app.sparkplug
+
+	var metrics []sparkplug.Metric
+	add := func(name string, value float64, unit string) {
+		m := sparkplug.Metric{Name: name, TimeStamp: r.TimeStamp, DataType: sparkplug.Double, Value: value}
+		if messageType == sparkplug.DBirth {
+			m.Properties = map[string]string{"engUnit": unit}
+		}
+		metrics = append(metrics, m)
+	}
+
+	add("Counter", r.Counter, r.UnitCounter)
+	add("Gauge", r.Gauge, r.UnitGauge)
+	if r.Import != nil && r.Export != nil {
+		add("Import/Counter", r.Import.Counter, r.UnitCounter)
+		add("Import/Gauge", r.Import.Gauge, r.UnitGauge)
+		add("Export/Counter", r.Export.Counter, r.UnitCounter)
+		add("Export/Gauge", r.Export.Gauge, r.UnitGauge)
+	}
+
+	n.seq = (n.seq + 1) % 256
+	p := sparkplug.Payload{TimeStamp: r.TimeStamp, Seq: sparkplug.Seq(n.seq), Metrics: metrics}
+
+	if err := n.handler.Publish(mqtt.Message{Topic: sparkplug.Topic(c.GroupID, messageType, c.EdgeNodeID, name), Payload: p.Marshal()}); err != nil {
+		debug.ErrorLog.Printf("can't publish sparkplug %v of meter %v: %v", messageType, name, err)
+	}
+}
+
+// sparkplugCommand handles the NCMD of the node, the rebirth command publishes the NBIRTH and the DBIRTHs again.
+func (app *App) sparkplugCommand(msg mqtt.Message) {
+	p, err := sparkplug.Unmarshal(msg.Payload)
+	if err != nil {
+		debug.ErrorLog.Printf("sparkplug NCMD: %v", err)
+		return
+	}
+
+	for _, m := range p.Metrics {
+		if rebirth, ok := m.Value.(bool); ok && m.Name == sparkplug.MetricRebirth && rebirth {
+			debug.InfoLog.Print("sparkplug rebirth requested")
+			go app.sparkplugBirth()
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"path/filepath"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/mqtt"
+	"s0counter/pkg/pulselog"
+	"s0counter/pkg/raspberry"
+	"s0counter/pkg/sparkplug"
+	"testing"
+	"time"
+)
+
+func TestSparkplugBdSeq(t *testing.T) {
+	dir := t.TempDir()
+	c := config.NewConfig()
+	c.Location = time.UTC
+	c.MQTT.Sparkplug.File = filepath.Join(dir, "sparkplug.yaml")
+
+	// the replay clock stands at the first pulse of the recording, until the replay is started
+	first := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	w, err := pulselog.Create(filepath.Join(dir, "pulses.rec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(pulselog.Record{Meter: "power", Pin: 17, TimeStamp: first}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gpio, err := raspberry.OpenReplay(filepath.Join(dir, "pulses.rec"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gpio.Close()
+
+	app := &App{config: c, gpio: gpio}
+	connect := func() (bdSeq uint64, death sparkplug.Payload) {
+		t.Helper()
+
+		msg := app.sparkplugWill()
+		if death, err = sparkplug.Unmarshal(msg.Payload); err != nil {
+			t.Fatal(err)
+		}
+		return app.sparkplug.bdSeq, death
+	}
+
+	// the bdSeq continues after a restart, e.g. 0, 1 and after the restart 2
+	for i, want := range []uint64{0, 1, 2} {
+		if i == 0 || i == 2 {
+			bdSeq, err := loadBdSeq(c.MQTT.Sparkplug.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.sparkplug = &sparkplugNode{handler: mqtt.New(), bdSeq: bdSeq}
+		}
+
+		bdSeq, death := connect()
+		if bdSeq != want {
+			t.Errorf("connection %v: got bdSeq %v, want %v", i, bdSeq, want)
+		}
+		if len(death.Metrics) != 1 || death.Metrics[0].Value != want {
+			t.Errorf("connection %v: got NDEATH metrics %v, want bdSeq %v", i, death.Metrics, want)
+		}
+		if !death.TimeStamp.Equal(first) {
+			t.Errorf("connection %v: got NDEATH time %v, want the replay clock %v", i, death.TimeStamp, first)
+		}
+	}
+}
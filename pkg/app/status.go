@@ -0,0 +1,50 @@
+package app
+
+import (
+	"s0counter/pkg/mqtt"
+
+	"github.com/womat/debug"
+)
+
+// availability of s0counter published on the status topic
+const (
+	statusOnline  = "online"
+	statusOffline = "offline"
+)
+
+// initStatus defines the last will offline, the broker publishes it if s0counter loses the connection,
+// e.g. on a power failure. After every (re)connect the birth message online is published.
+func (app *App) initStatus() {
+	if app.config.MQTT.StatusTopic == "" {
+		return
+	}
+
+	app.mqtt.SetWill(app.statusMessage(statusOffline))
+	app.mqtt.OnConnect(app.publishOnline)
+}
+
+// publishOnline publishes the availability online on the status topic, it's called after every (re)connect.
+func (app *App) publishOnline() {
+	app.mqtt.C <- app.statusMessage(statusOnline)
+}
+
+// publishOffline publishes the availability offline on a graceful shutdown, before the connection is closed.
+func (app *App) publishOffline() {
+	if app.config.MQTT.StatusTopic == "" {
+		return
+	}
+
+	if err := app.mqtt.Publish(app.statusMessage(statusOffline)); err != nil {
+		debug.ErrorLog.Printf("can't publish status offline: %v", err)
+	}
+}
+
+// statusMessage returns the retained message of the availability on the status topic.
+func (app *App) statusMessage(status string) mqtt.Message {
+	return mqtt.Message{
+		Qos:      1,
+		Retained: true,
+		Topic:    app.config.MQTT.StatusTopic,
+		Payload:  []byte(status),
+	}
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"s0counter/pkg/meter"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/valyala/fasthttp"
+	"github.com/womat/debug"
+)
+
+const (
+	// streamBuffer is the number of events buffered per client, further events are dropped for slow clients
+	streamBuffer = 64
+	// streamKeepAlive is the interval of the keep alive comments of server-sent events
+	streamKeepAlive = 15 * time.Second
+)
+
+// types of the stream events
+const (
+	eventPulse    = "pulse"
+	eventInterval = "interval"
+)
+
+// Event is pushed to the stream clients on every accepted pulse and on every data collection interval.
+type Event struct {
+	Type        string     // pulse or interval
+	Meter       string     // name of the meter
+	TimeStamp   time.Time  // time of the pulse or the interval
+	Counter     float64    // current counter (aktueller Zählerstand), eg kWh, l, m³
+	UnitCounter string     // unit of current meter counter e.g. kWh, l, m³
+	Gauge       float64    // mass flow rate per time unit  (= counter/time(h)), e.g. kW, l/h, m³/h
+	UnitGauge   string     // unit of gauge, eg Wh, l/s, m³/h
+	Import      *direction `json:",omitempty"` // import counter, gauge and consumption of a bidirectional meter
+	Export      *direction `json:",omitempty"` // export counter, gauge and consumption of a bidirectional meter
+}
+
+// eventHub distributes the events to the subscribed stream clients.
+type eventHub struct {
+	sync.Mutex
+	// clients contains the meter filter of each client, an empty filter subscribes all meters
+	clients map[chan Event]map[string]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: map[chan Event]map[string]bool{}}
+}
+
+// subscribe registers a new client for the events of the meters.
+func (h *eventHub) subscribe(meters map[string]bool) chan Event {
+	h.Lock()
+	defer h.Unlock()
+
+	c := make(chan Event, streamBuffer)
+	h.clients[c] = meters
+	return c
+}
+
+// unsubscribe removes the client.
+func (h *eventHub) unsubscribe(c chan Event) {
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.clients, c)
+}
+
+// subscribed returns true, if a client has subscribed the events of the meter.
+func (h *eventHub) subscribed(meter string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, meters := range h.clients {
+		if len(meters) == 0 || meters[meter] {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends the event to all clients of the meter, the event is dropped for clients with a full buffer.
+func (h *eventHub) publish(e Event) {
+	h.Lock()
+	defer h.Unlock()
+
+	for c, meters := range h.clients {
+		if len(meters) > 0 && !meters[e.Meter] {
+			continue
+		}
+
+		select {
+		case c <- e:
+		default:
+			debug.WarningLog.Printf("stream client too slow, drop %v event of meter %v", e.Type, e.Meter)
+		}
+	}
+}
+
+// publishEvent sends an event of meter m to the stream clients, the lock of the meter must be held.
+// Without a client of the meter, the values aren't calculated.
+func (app *App) publishEvent(typ, name string, m *meter.Meter, t time.Time) {
+	if !app.events.subscribed(name) {
+		return
+	}
+
+	app.events.publish(Event{
+		Type:        typ,
+		Meter:       name,
+		TimeStamp:   t,
+		Counter:     calcCounter(m),
+		UnitCounter: m.Config.UnitCounter,
+		Gauge:       calcGauge(m, t),
+		UnitGauge:   m.Config.UnitGauge,
+		Import:      app.calcImport(m, t),
+		Export:      app.calcExport(m, t),
+	})
+}
+
+// HandleStream pushes the events of the meters as server-sent events or,
+// if the request is a websocket upgrade, as websocket messages.
+// The meters can be filtered by the query parameter meter, e.g. /stream?meter=wallbox,heatpump
+func (app *App) HandleStream() fiber.Handler {
+	ws := websocket.New(app.handleWebSocket)
+
+	return func(ctx *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(ctx) {
+			debug.InfoLog.Print("web request stream (websocket)")
+			return ws(ctx)
+		}
+
+		debug.InfoLog.Print("web request stream (server-sent events)")
+
+		meters, err := app.streamFilter(ctx.Query("meter"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+
+		ctx.Set(fiber.HeaderContentType, "text/event-stream")
+		ctx.Set(fiber.HeaderCacheControl, "no-cache")
+		ctx.Set(fiber.HeaderConnection, "keep-alive")
+
+		c := app.events.subscribe(meters)
+		ctx.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			defer app.events.unsubscribe(c)
+
+			keepAlive := time.NewTicker(streamKeepAlive)
+			defer keepAlive.Stop()
+
+			for {
+				select {
+				case e := <-c:
+					b, err := json.Marshal(e)
+					if err != nil {
+						debug.ErrorLog.Printf("stream marshal: %v", err)
+						continue
+					}
+					_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+				case <-keepAlive.C:
+					_, _ = fmt.Fprint(w, ": keep alive\n\n")
+				}
+
+				// an error signals that the client has closed the connection
+				if err := w.Flush(); err != nil {
+					debug.InfoLog.Print("stream client disconnected")
+					return
+				}
+			}
+		}))
+
+		return nil
+	}
+}
+
+// handleWebSocket sends the events of the meters as json messages until the client closes the connection.
+func (app *App) handleWebSocket(conn *websocket.Conn) {
+	meters, err := app.streamFilter(conn.Query("meter"))
+	if err != nil {
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		return
+	}
+
+	c := app.events.subscribe(meters)
+	defer app.events.unsubscribe(c)
+
+	// the websocket must be read to detect the close of the connection, received messages are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			debug.InfoLog.Print("stream client disconnected")
+			return
+		case e := <-c:
+			if err := conn.WriteJSON(e); err != nil {
+				debug.InfoLog.Printf("stream client disconnected: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// streamFilter returns the meters of a comma separated list, an empty list returns an empty filter (all meters).
+func (app *App) streamFilter(list string) (map[string]bool, error) {
+	meters := map[string]bool{}
+	for _, n := range strings.Split(list, ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		if _, ok := app.meters[n]; !ok {
+			return nil, fmt.Errorf("unknown meter %q", n)
+		}
+		meters[n] = true
+	}
+	return meters, nil
+}
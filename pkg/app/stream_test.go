@@ -0,0 +1,51 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventHub(t *testing.T) {
+	h := newEventHub()
+	if h.subscribed("wallbox") {
+		t.Error("meter wallbox is subscribed without clients")
+	}
+
+	all := h.subscribe(map[string]bool{})
+	wallbox := h.subscribe(map[string]bool{"wallbox": true})
+	if !h.subscribed("heatpump") || !h.subscribed("wallbox") {
+		t.Error("the meters aren't subscribed by the client of all meters")
+	}
+
+	// the filter of a client drops the events of other meters
+	h.publish(Event{Type: eventPulse, Meter: "heatpump"})
+	h.publish(Event{Type: eventPulse, Meter: "wallbox"})
+	if len(all) != 2 || len(wallbox) != 1 {
+		t.Errorf("got %v events of all meters and %v events of meter wallbox, want 2 and 1", len(all), len(wallbox))
+	}
+	if e := <-wallbox; e.Meter != "wallbox" {
+		t.Errorf("got event of meter %v, want wallbox", e.Meter)
+	}
+
+	h.unsubscribe(all)
+	if h.subscribed("heatpump") || !h.subscribed("wallbox") {
+		t.Error("the subscriptions of the unsubscribed client are kept")
+	}
+
+	// the events are dropped for a client with a full buffer, publish doesn't block
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < streamBuffer+10; i++ {
+			h.publish(Event{Type: eventInterval, Meter: "wallbox"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocks on a full client buffer")
+	}
+	if len(wallbox) != streamBuffer {
+		t.Errorf("got %v buffered events, want %v", len(wallbox), streamBuffer)
+	}
+}
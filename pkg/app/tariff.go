@@ -0,0 +1,62 @@
+package app
+
+import (
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/meter"
+	"s0counter/pkg/tariff"
+	"time"
+)
+
+// newTariffSchedule creates the tariff schedule of the configuration.
+// If no default tariff is defined, there is no schedule and nil is returned.
+func newTariffSchedule(c config.TariffConfig) (*tariff.Schedule, error) {
+	if c.Default == "" {
+		return nil, nil
+	}
+
+	s := tariff.Schedule{
+		Default:       c.Default,
+		HolidayTariff: c.HolidayTariff,
+		Holidays:      map[string]bool{},
+	}
+
+	if c.HolidayFile != "" {
+		h, err := tariff.LoadHolidays(c.HolidayFile)
+		if err != nil {
+			return nil, err
+		}
+		s.Holidays = h
+	}
+
+	for _, w := range c.Schedule {
+		weekdays := map[time.Weekday]bool{}
+		for _, d := range w.Weekdays {
+			weekdays[d] = true
+		}
+
+		s.Windows = append(s.Windows, tariff.Window{Tariff: w.Tariff, Weekdays: weekdays, From: w.From, To: w.To})
+	}
+
+	return &s, nil
+}
+
+// activeTariff returns the active tariff of the meter, if the meter has no tariff registers, it returns "".
+func (app *App) activeTariff(m *meter.Meter, t time.Time) string {
+	if !m.Config.Tariff || app.tariff == nil {
+		return ""
+	}
+	return app.tariff.Active(t.In(app.config.Location))
+}
+
+// calcTariffs returns the counters of the tariff registers, eg kWh, l, m³
+func calcTariffs(m *meter.Meter) map[string]float64 {
+	if len(m.S0.Tariffs) == 0 {
+		return nil
+	}
+
+	f := map[string]float64{}
+	for t, ticks := range m.S0.Tariffs {
+		f[t] = float64(ticks) / m.Config.CounterConstant
+	}
+	return f
+}
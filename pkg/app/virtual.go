@@ -0,0 +1,60 @@
+package app
+
+import (
+	"math"
+	"s0counter/pkg/meter"
+	"time"
+)
+
+// evalVirtual calculates the expression of the virtual meter, the values of the referenced meters are returned by value.
+// The referenced meters are read locked while their value is calculated.
+// If the expression can't be calculated, e.g. because of a division by zero, 0 is returned.
+func evalVirtual(m *meter.Meter, value func(o *meter.Meter) float64) float64 {
+	f := m.Config.Expr.Eval(func(name string) float64 {
+		o := m.Operands[name]
+		o.RLock()
+		defer o.RUnlock()
+		return value(o)
+	})
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
+
+// calcVirtualConsumption returns the consumption of the current and the previous periods of the virtual meter at time t.
+// The consumption of each period is calculated from the consumption of the referenced meters.
+func (app *App) calcVirtualConsumption(m *meter.Meter, t time.Time) (c consumption) {
+	operands := map[string]consumption{}
+	for name, o := range m.Operands {
+		o.RLock()
+		operands[name] = app.calcConsumption(o, t)
+		o.RUnlock()
+	}
+
+	fields := []struct {
+		result *float64
+		value  func(consumption) float64
+	}{
+		{&c.ThisHour, func(o consumption) float64 { return o.ThisHour }},
+		{&c.LastHour, func(o consumption) float64 { return o.LastHour }},
+		{&c.Today, func(o consumption) float64 { return o.Today }},
+		{&c.Yesterday, func(o consumption) float64 { return o.Yesterday }},
+		{&c.ThisWeek, func(o consumption) float64 { return o.ThisWeek }},
+		{&c.LastWeek, func(o consumption) float64 { return o.LastWeek }},
+		{&c.ThisMonth, func(o consumption) float64 { return o.ThisMonth }},
+		{&c.LastMonth, func(o consumption) float64 { return o.LastMonth }},
+		{&c.ThisYear, func(o consumption) float64 { return o.ThisYear }},
+		{&c.LastYear, func(o consumption) float64 { return o.LastYear }},
+	}
+
+	for _, f := range fields {
+		v := m.Config.Expr.Eval(func(name string) float64 { return f.value(operands[name]) })
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			v = 0
+		}
+		*f.result = toFixed(v, counterPrecision)
+	}
+	return
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"s0counter/pkg/app/config"
+	"s0counter/pkg/webhook"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/womat/debug"
+)
+
+// types of the webhook events
+const (
+	webhookPulses   = "pulses"
+	webhookPeriod   = "period"
+	webhookAlarm    = "alarm"
+	webhookStalled  = "stalled"
+	webhookStartup  = "startup"
+	webhookShutdown = "shutdown"
+)
+
+// WebhookEvent is the body of the webhook requests, if the endpoint has no template.
+// A template of an endpoint gets the WebhookEvent, e.g. {{.Event}} {{.Meter}} {{.Data.Consumption}}
+type WebhookEvent struct {
+	Event     string      // pulses, period, alarm, stalled, startup, shutdown
+	TimeStamp time.Time   // time of the event
+	Meter     string      `json:",omitempty"` // name of the meter, it's empty for startup and shutdown
+	Data      interface{} `json:",omitempty"` // PulseBatch, PeriodRecord, AlarmEvent, StallRecord or VersionRecord
+}
+
+// PulseBatch contains the pulses of a meter, which have been received during the batch interval.
+type PulseBatch struct {
+	Start        time.Time // start of the batch interval
+	End          time.Time // end of the batch interval
+	Pulses       uint64    // pulses of the batch interval, the import pulses of a bidirectional meter
+	ExportPulses uint64    `json:",omitempty"` // export pulses of a bidirectional meter
+	Counter      float64   // current counter (aktueller Zählerstand), eg kWh, l, m³
+	UnitCounter  string    // unit of current meter counter e.g. kWh, l, m³
+}
+
+// StallRecord is sent, if a meter has no pulse for the stall timeout and again, if it has a pulse again.
+type StallRecord struct {
+	Stalled   bool      // true, if the meter is stalled
+	LastPulse time.Time // time of the last pulse, it's zero if the meter has never had a pulse
+}
+
+// VersionRecord is sent on startup and shutdown.
+type VersionRecord struct {
+	Module  string // name of the application
+	Version string // version of the application
+}
+
+// webhooks holds the webhook endpoints and the state of the pulse batches and the stalled meters.
+// The state is only used by the data collection loop, except the ticks, which are shifted by the command setcounter.
+type webhooks struct {
+	endpoints  map[string]*webhook.Endpoint
+	batchStart time.Time
+	sync.Mutex                      // protects ticks, it's locked within the lock of the meter
+	ticks      map[string][2]uint64 // import and export ticks of the meters at the start of the batch interval
+	stalled    map[string]bool
+	started    time.Time
+}
+
+// initWebhooks starts the delivery of the webhook endpoints.
+func (app *App) initWebhooks() {
+	w := &webhooks{
+		endpoints:  map[string]*webhook.Endpoint{},
+		batchStart: app.now(),
+		ticks:      map[string][2]uint64{},
+		stalled:    map[string]bool{},
+		started:    app.now(),
+	}
+
+	for name, c := range app.config.Webhook.Endpoints {
+		w.endpoints[name] = webhook.New(name, webhook.Options{
+			URL:         c.URL,
+			Secret:      c.Secret,
+			ContentType: c.ContentType,
+			Headers:     c.Headers,
+			Timeout:     c.Timeout,
+			Retries:     c.Retries,
+			Backoff:     c.Backoff,
+			MaxBackoff:  c.MaxBackoff,
+		})
+	}
+
+	for name, m := range app.meters {
+		m.RLock()
+		w.ticks[name] = [2]uint64{m.S0.Tick, m.Export.Tick}
+		m.RUnlock()
+	}
+
+	app.webhooks = w
+}
+
+// closeWebhooks stops the delivery of the webhook endpoints and posts the shutdown event once, without retries.
+func (app *App) closeWebhooks() {
+	if app.webhooks == nil {
+		return
+	}
+
+	for _, e := range app.webhooks.endpoints {
+		e.Close()
+	}
+
+	ev := WebhookEvent{Event: webhookShutdown, TimeStamp: time.Now(), Data: VersionRecord{Module: MODULE, Version: VERSION}}
+	for name, c := range app.config.Webhook.Endpoints {
+		if !c.EventFilter[ev.Event] {
+			continue
+		}
+
+		r, err := webhookRequest(name, c, ev)
+		if err != nil {
+			continue
+		}
+		if err = app.webhooks.endpoints[name].Post(r); err != nil {
+			debug.ErrorLog.Printf("webhook %v: can't post %v event: %v", name, ev.Event, err)
+		}
+	}
+}
+
+// notify queues the event to the endpoints, which subscribed the event and the meter.
+func (app *App) notify(ev WebhookEvent) {
+	if app.webhooks == nil {
+		return
+	}
+
+	for name, c := range app.config.Webhook.Endpoints {
+		if !c.EventFilter[ev.Event] || (ev.Meter != "" && len(c.MeterFilter) > 0 && !c.MeterFilter[ev.Meter]) {
+			continue
+		}
+
+		if r, err := webhookRequest(name, c, ev); err == nil {
+			app.webhooks.endpoints[name].Send(r)
+		}
+	}
+}
+
+// webhookRequest returns the request of the event, the body is the output of the template or the json of the event.
+func webhookRequest(name string, c config.WebhookEndpointConfig, ev WebhookEvent) (webhook.Request, error) {
+	var b []byte
+	var err error
+
+	if c.Template != nil {
+		var buf bytes.Buffer
+		err = c.Template.Execute(&buf, ev)
+		b = buf.Bytes()
+	} else {
+		b, err = json.Marshal(ev)
+	}
+
+	if err != nil {
+		debug.ErrorLog.Printf("webhook %v: can't render %v event: %v", name, ev.Event, err)
+	}
+	return webhook.Request{Event: ev.Event, Body: b}, err
+}
+
+// checkWebhooks sends the pulse batches, if the batch interval has been elapsed, and the stalled meters at time t.
+// It's called every data collection interval.
+func (app *App) checkWebhooks(t time.Time) {
+	w := app.webhooks
+	if w == nil || len(w.endpoints) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(app.meters))
+	for name, m := range app.meters {
+		// virtual meters have no pulses
+		if m.Config.Expr == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	batch := t.Sub(w.batchStart) >= app.config.Webhook.BatchInterval
+	for _, name := range names {
+		m := app.meters[name]
+		m.RLock()
+		ticks := [2]uint64{m.S0.Tick, m.Export.Tick}
+		counter := calcCounter(m)
+		last := m.S0.TimeStamp
+		if m.Export.TimeStamp.After(last) {
+			last = m.Export.TimeStamp
+		}
+		// the start ticks are read within the lock of the meter, so they fit to the ticks, even if setcounter shifts them
+		w.Lock()
+		old := w.ticks[name]
+		if batch {
+			w.ticks[name] = ticks
+		}
+		w.Unlock()
+		m.RUnlock()
+
+		// the ticks can be decreased below the start of the batch by the command setcounter, the batch starts again
+		if batch && ticks[0] >= old[0] && ticks[1] >= old[1] && ticks != old {
+			app.notify(WebhookEvent{Event: webhookPulses, TimeStamp: t, Meter: name, Data: PulseBatch{
+				Start: w.batchStart, End: t, Pulses: ticks[0] - old[0], ExportPulses: ticks[1] - old[1],
+				Counter: counter, UnitCounter: m.Config.UnitCounter,
+			}})
+		}
+
+		if app.config.Webhook.StallTimeout > 0 {
+			since := last
+			if since.IsZero() {
+				since = w.started
+			}
+
+			if stalled := t.Sub(since) > app.config.Webhook.StallTimeout; stalled != w.stalled[name] {
+				w.stalled[name] = stalled
+				debug.InfoLog.Printf("meter %v stalled: %v", name, stalled)
+				app.notify(WebhookEvent{Event: webhookStalled, TimeStamp: t, Meter: name, Data: StallRecord{Stalled: stalled, LastPulse: last}})
+			}
+		}
+	}
+
+	if batch {
+		w.batchStart = t
+	}
+}
@@ -8,11 +8,18 @@ import (
 )
 
 type resp struct {
-	TimeStamp   time.Time // timestamp of last gauge calculation
-	Counter     float64   // current counter (aktueller Zählerstand), eg kWh, l, m³
-	UnitCounter string    // unit of current meter counter e.g. kWh, l, m³
-	Gauge       float64   // mass flow rate per time unit  (= counter/time(h)), e.g. kW, l/h, m³/h
-	UnitGauge   string    // unit of gauge, eg Wh, l/s, m³/h
+	TimeStamp   time.Time          // timestamp of last gauge calculation
+	Counter     float64            // current counter (aktueller Zählerstand), eg kWh, l, m³
+	UnitCounter string             // unit of current meter counter e.g. kWh, l, m³
+	Gauge       float64            // mass flow rate per time unit  (= counter/time(h)), e.g. kW, l/h, m³/h
+	UnitGauge   string             // unit of gauge, eg Wh, l/s, m³/h
+	Rejected    uint64             // pulses rejected by the plausibility filter
+	Tariff      string             `json:",omitempty"` // active tariff
+	Tariffs     map[string]float64 `json:",omitempty"` // counter per tariff, eg kWh, l, m³
+	Consumption consumption        // consumption of the current and the previous periods, eg kWh, l, m³
+	Import      *direction         `json:",omitempty"` // import counter, gauge and consumption of a bidirectional meter
+	Export      *direction         `json:",omitempty"` // export counter, gauge and consumption of a bidirectional meter
+	Demand      *demandRecord      `json:",omitempty"` // average power of the current window and maximum demand
 }
 
 // runWebServer starts the applications web server and listens for web requests.
@@ -28,15 +35,23 @@ func (app *App) HandleCurrentData() fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		debug.InfoLog.Print("web request currentdata")
 
+		now := app.now()
 		res := map[string]resp{}
 		for n, m := range app.meters {
 			m.RLock()
 			res[n] = resp{
-				TimeStamp:   time.Now(),
+				TimeStamp:   now,
 				Counter:     calcCounter(m),
 				UnitCounter: m.Config.UnitCounter,
-				Gauge:       calcGauge(m),
+				Gauge:       calcGauge(m, now),
 				UnitGauge:   m.Config.UnitGauge,
+				Rejected:    m.S0.Rejected + m.Export.Rejected,
+				Tariff:      app.activeTariff(m, now),
+				Tariffs:     calcTariffs(m),
+				Consumption: app.calcConsumption(m, now),
+				Import:      app.calcImport(m, now),
+				Export:      app.calcExport(m, now),
+				Demand:      app.calcDemand(m, now),
 			}
 			m.RUnlock()
 		}
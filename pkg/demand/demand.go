@@ -0,0 +1,197 @@
+// Package demand provides demand registers, they track the s0 ticks of fixed or sliding time windows
+// and the maximum demand of the day and the month, e.g. the 15-minute maximum demand of commercial tariffs.
+package demand
+
+import (
+	"math"
+	"s0counter/pkg/period"
+	"time"
+)
+
+// snapshotsPerWindow is the maximum number of tick snapshots of a sliding window.
+const snapshotsPerWindow = 900
+
+// Value is the s0 ticks of a closed window.
+type Value struct {
+	Ticks     uint64    `yaml:"ticks"`     // s0 ticks of the window
+	TimeStamp time.Time `yaml:"timestamp"` // end of the window, it's zero if no window has been closed
+}
+
+// Register holds the current window and the maximum windows of the current and the previous day and month.
+type Register struct {
+	Start      time.Time `yaml:"start"`      // start of the current fixed window, the first window starts at the start of the register
+	StartTicks uint64    `yaml:"startticks"` // s0 ticks at the start of the current fixed window
+	Last       Value     `yaml:"last"`       // last complete fixed window, it's zero if the last closed window is incomplete
+	Today      Value     `yaml:"today"`      // maximum window of the current day
+	Yesterday  Value     `yaml:"yesterday"`  // maximum window of the previous day
+	ThisMonth  Value     `yaml:"thismonth"`  // maximum window of the current month
+	LastMonth  Value     `yaml:"lastmonth"`  // maximum window of the previous month
+
+	// snapshots are the s0 ticks of a sliding window, they aren't persisted
+	snapshots []snapshot
+}
+
+type snapshot struct {
+	t     time.Time
+	ticks uint64
+}
+
+// Start returns the start of the fixed window which contains t, the windows are aligned to the start of the day of t.
+func Start(window time.Duration, t time.Time) time.Time {
+	day := period.Day.Start(t)
+	return day.Add(t.Sub(day) / window * window)
+}
+
+// Update closes the fixed window or moves the sliding window to time t, the ticks are the current s0 ticks.
+// The maximum demand is updated with each closed fixed window or each completely covered sliding window.
+// Update returns true, if a fixed window has been closed.
+func (r *Register) Update(window time.Duration, sliding bool, t time.Time, ticks uint64) bool {
+	if sliding {
+		r.roll(t)
+		r.slide(window, t, ticks)
+		return false
+	}
+
+	// the closed window is recorded before, a window which ends at midnight belongs to the ending day
+	defer r.roll(t)
+
+	s := Start(window, t)
+	switch {
+	case r.Start.IsZero():
+		r.Start, r.StartTicks = t, ticks
+		return false
+	case !s.After(r.Start):
+		return false
+	}
+
+	v := Value{TimeStamp: Start(window, r.Start).Add(window)}
+	if ticks >= r.StartTicks {
+		v.Ticks = ticks - r.StartTicks
+	}
+
+	// the ticks of the first window of the register or of a window before missed windows, e.g. the application was stopped,
+	// are incomplete and the window isn't recorded
+	r.Last = Value{}
+	if r.Start.Equal(Start(window, r.Start)) && s.Equal(v.TimeStamp) {
+		r.Last = v
+		r.record(v)
+	}
+
+	r.Start, r.StartTicks = s, ticks
+	return true
+}
+
+// At returns a copy of the register, which is updated to time t, the register itself isn't changed.
+func (r Register) At(window time.Duration, sliding bool, t time.Time, ticks uint64) Register {
+	r.snapshots = append([]snapshot(nil), r.snapshots...)
+	r.Update(window, sliding, t, ticks)
+	return r
+}
+
+// slide adds a snapshot of the ticks to the sliding window and records the demand of the window, if it's completely covered.
+func (r *Register) slide(window time.Duration, t time.Time, ticks uint64) {
+	if n := len(r.snapshots); n == 0 || t.Sub(r.snapshots[n-1].t) >= window/snapshotsPerWindow {
+		r.snapshots = append(r.snapshots, snapshot{t: t, ticks: ticks})
+	}
+
+	// the latest snapshot at or before the start of the window is kept as start of the window
+	start := t.Add(-window)
+	i := 0
+	for i+1 < len(r.snapshots) && !r.snapshots[i+1].t.After(start) {
+		i++
+	}
+	r.snapshots = r.snapshots[i:]
+
+	s := r.snapshots[0]
+	if s.t.After(start) {
+		return
+	}
+
+	// the ticks at the start of the window are interpolated between the snapshots around the start,
+	// so the demand covers exactly the window and not the time since an older snapshot
+	next := snapshot{t: t, ticks: ticks}
+	if len(r.snapshots) > 1 {
+		next = r.snapshots[1]
+	}
+	if next.t.After(s.t) && next.ticks >= s.ticks {
+		s.ticks += uint64(math.Round(float64(next.ticks-s.ticks) * float64(start.Sub(s.t)) / float64(next.t.Sub(s.t))))
+	}
+	s.t = start
+	r.snapshots[0] = s
+
+	if ticks >= s.ticks {
+		r.record(Value{Ticks: ticks - s.ticks, TimeStamp: t})
+	}
+}
+
+// record updates the maximum demand of the day and the month.
+func (r *Register) record(v Value) {
+	r.roll(v.TimeStamp)
+
+	if v.Ticks > r.Today.Ticks || r.Today.TimeStamp.IsZero() {
+		r.Today = v
+	}
+	if v.Ticks > r.ThisMonth.Ticks || r.ThisMonth.TimeStamp.IsZero() {
+		r.ThisMonth = v
+	}
+}
+
+// roll moves the maximum demand of the day and the month to the previous day and month, if t is in a new day or month.
+// A window which ends at midnight belongs to the ending day.
+func (r *Register) roll(t time.Time) {
+	day := func(v Value) time.Time { return period.Day.Start(v.TimeStamp.Add(-time.Nanosecond)) }
+	month := func(v Value) time.Time { return period.Month.Start(v.TimeStamp.Add(-time.Nanosecond)) }
+	now := t.Add(-time.Nanosecond)
+
+	if !r.Today.TimeStamp.IsZero() && period.Day.Start(now).After(day(r.Today)) {
+		r.Yesterday = Value{}
+		if period.Day.Start(now).Equal(period.Day.Start(day(r.Today).AddDate(0, 0, 1))) {
+			r.Yesterday = r.Today
+		}
+		r.Today = Value{}
+	}
+
+	if !r.ThisMonth.TimeStamp.IsZero() && period.Month.Start(now).After(month(r.ThisMonth)) {
+		r.LastMonth = Value{}
+		if period.Month.Start(now).Equal(month(r.ThisMonth).AddDate(0, 1, 0)) {
+			r.LastMonth = r.ThisMonth
+		}
+		r.ThisMonth = Value{}
+	}
+}
+
+// Ticks returns the s0 ticks of the current window, the ticks are the current s0 ticks.
+// The current window of a sliding window are the last window ticks, of a fixed window the ticks since its start.
+func (r *Register) Ticks(sliding bool, ticks uint64) uint64 {
+	start := r.StartTicks
+	if sliding {
+		if len(r.snapshots) == 0 {
+			return 0
+		}
+		start = r.snapshots[0].ticks
+	}
+
+	if ticks < start {
+		return 0
+	}
+	return ticks - start
+}
+
+// Shift shifts the start ticks of the current window, if the s0 ticks are changed from old to ticks,
+// so the ticks of the current window are kept.
+func (r *Register) Shift(ticks, old uint64) {
+	shift := func(start uint64) uint64 {
+		if ticks >= old {
+			return start + (ticks - old)
+		}
+		if d := old - ticks; start >= d {
+			return start - d
+		}
+		return 0
+	}
+
+	r.StartTicks = shift(r.StartTicks)
+	for i := range r.snapshots {
+		r.snapshots[i].ticks = shift(r.snapshots[i].ticks)
+	}
+}
@@ -0,0 +1,140 @@
+package demand
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedWindows(t *testing.T) {
+	window := 15 * time.Minute
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 10, h, m, 0, 0, time.UTC) }
+
+	var r Register
+	for _, tc := range []struct {
+		t      time.Time
+		ticks  uint64
+		closed bool
+		start  time.Time
+		last   Value
+		today  Value
+	}{
+		// the first window starts with the register and is incomplete
+		{at(10, 7), 0, false, at(10, 7), Value{}, Value{}},
+		{at(10, 14), 80, false, at(10, 7), Value{}, Value{}},
+		{at(10, 15), 100, true, at(10, 15), Value{}, Value{}},
+		// the windows are aligned to the day
+		{at(10, 31), 250, true, at(10, 30), Value{150, at(10, 30)}, Value{150, at(10, 30)}},
+		{at(10, 45), 320, true, at(10, 45), Value{70, at(10, 45)}, Value{150, at(10, 30)}},
+		// the window is closed by the first update of the next window, e.g. by the next pulse
+		{at(11, 10), 400, true, at(11, 0), Value{80, at(11, 0)}, Value{150, at(10, 30)}},
+		// the window before missed windows is incomplete
+		{at(11, 50), 500, true, at(11, 45), Value{}, Value{150, at(10, 30)}},
+		{at(12, 0), 700, true, at(12, 0), Value{200, at(12, 0)}, Value{200, at(12, 0)}},
+	} {
+		if got := r.Update(window, false, tc.t, tc.ticks); got != tc.closed {
+			t.Errorf("%v: got closed %v, want %v", tc.t, got, tc.closed)
+		}
+		if !r.Start.Equal(tc.start) || r.Last != tc.last || r.Today != tc.today {
+			t.Errorf("%v: got start %v, last %v, today %v, want %v, %v, %v", tc.t, r.Start, r.Last, r.Today, tc.start, tc.last, tc.today)
+		}
+	}
+
+	// At doesn't change the register
+	if c := r.At(window, false, at(12, 15), 900); c.Last.Ticks != 200 || !r.Start.Equal(at(12, 0)) {
+		t.Errorf("at: got last %v and start %v, want 200 and %v", c.Last.Ticks, r.Start, at(12, 0))
+	}
+}
+
+func TestRoll(t *testing.T) {
+	window := 15 * time.Minute
+	day1 := time.Date(2026, 3, 31, 23, 30, 0, 0, time.UTC)
+
+	var r Register
+	r.Update(window, false, day1, 0)
+	r.Update(window, false, day1.Add(window), 100)
+	// the window, which ends at midnight, belongs to the ending day and month
+	r.Update(window, false, day1.Add(2*window), 300)
+	if r.Today.Ticks != 200 || r.ThisMonth.Ticks != 200 || !r.Yesterday.TimeStamp.IsZero() {
+		t.Errorf("midnight: got today %v, this month %v, yesterday %v, want 200, 200, 0", r.Today, r.ThisMonth, r.Yesterday)
+	}
+
+	r.Update(window, false, day1.Add(3*window), 350)
+	if r.Today.Ticks != 50 || r.Yesterday.Ticks != 200 || r.ThisMonth.Ticks != 50 || r.LastMonth.Ticks != 200 {
+		t.Errorf("next day: got today %v, yesterday %v, this month %v, last month %v, want 50, 200, 50, 200",
+			r.Today, r.Yesterday, r.ThisMonth, r.LastMonth)
+	}
+
+	// the maximum of a day or month before a missed day or month isn't the previous one
+	later := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	r.Update(window, false, later, 400)
+	r.Update(window, false, later.Add(window), 410)
+	r.Update(window, false, later.Add(2*window), 430)
+	if r.Today.Ticks != 20 || !r.Yesterday.TimeStamp.IsZero() || r.ThisMonth.Ticks != 20 || !r.LastMonth.TimeStamp.IsZero() {
+		t.Errorf("missed days: got today %v, yesterday %v, this month %v, last month %v, want 20, 0, 20, 0",
+			r.Today, r.Yesterday, r.ThisMonth, r.LastMonth)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := 15 * time.Minute
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+
+	// 10 ticks per minute, the snapshots are 10 minutes apart
+	var r Register
+	r.Update(window, true, t0, 0)
+	r.Update(window, true, t0.Add(10*time.Minute), 100)
+	if !r.Today.TimeStamp.IsZero() {
+		t.Errorf("incomplete window: got today %v, want none", r.Today)
+	}
+
+	// the window 10:05 - 10:20 contains 150 ticks, not the 200 ticks since 10:00
+	r.Update(window, true, t0.Add(20*time.Minute), 200)
+	if r.Today.Ticks != 150 {
+		t.Errorf("got today %v ticks, want 150", r.Today.Ticks)
+	}
+	if got := r.Ticks(true, 200); got != 150 {
+		t.Errorf("got current %v ticks, want 150", got)
+	}
+
+	r.Update(window, true, t0.Add(25*time.Minute), 260)
+	if got := r.Ticks(true, 260); got != 160 {
+		t.Errorf("got current %v ticks, want 160", got)
+	}
+}
+
+func TestShift(t *testing.T) {
+	window := 15 * time.Minute
+	t0 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name    string
+		sliding bool
+	}{
+		{"fixed", false},
+		{"sliding", true},
+	} {
+		var r Register
+		r.Update(window, tc.sliding, t0, 100)
+		r.Update(window, tc.sliding, t0.Add(10*time.Minute), 150)
+		want := uint64(50)
+		if got := r.Ticks(tc.sliding, 150); got != want {
+			t.Errorf("%v: got %v ticks, want %v", tc.name, got, want)
+		}
+
+		// the ticks of the current window are kept, if the counter is set
+		r.Shift(1150, 150)
+		if got := r.Ticks(tc.sliding, 1150); got != want {
+			t.Errorf("%v: increased counter: got %v ticks, want %v", tc.name, got, want)
+		}
+		r.Shift(1100, 1150)
+		if got := r.Ticks(tc.sliding, 1100); got != want {
+			t.Errorf("%v: decreased counter: got %v ticks, want %v", tc.name, got, want)
+		}
+
+		// the start can't be less than 0
+		r.Shift(0, 1100)
+		if got := r.Ticks(tc.sliding, 0); got != 0 {
+			t.Errorf("%v: counter set to 0: got %v ticks, want 0", tc.name, got)
+		}
+	}
+}
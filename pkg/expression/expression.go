@@ -0,0 +1,317 @@
+// Package expression provides arithmetic expressions over named variables, e.g. "grid + pv - wallbox".
+//
+// Supported are numbers, variables, the operators + - * / and parentheses. A variable is a name of
+// ascii letters, digits and underscores, other names are written in brackets, e.g. "[heat-pump] * 0.5".
+package expression
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed expression.
+type Expression struct {
+	src  string
+	root node
+}
+
+type node interface {
+	eval(value func(string) float64) float64
+	// dim returns 0 for a constant, 1 for a linear term of variables and -1 for a non-linear term
+	dim() int
+	variables(v map[string]bool)
+	// coefficients adds the coefficients of the variables of a linear term multiplied by factor to c
+	coefficients(c map[string]float64, factor float64)
+}
+
+type number float64
+
+type variable string
+
+type unary struct {
+	x node
+}
+
+type binary struct {
+	op   byte
+	x, y node
+}
+
+// Parse parses the expression.
+func Parse(s string) (*Expression, error) {
+	p := parser{src: s}
+	p.next()
+
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at position %v", p.tok, p.start+1)
+	}
+
+	return &Expression{src: s, root: root}, nil
+}
+
+// Eval calculates the expression, the values of the variables are returned by the function value.
+func (e *Expression) Eval(value func(name string) float64) float64 {
+	return e.root.eval(value)
+}
+
+// Variables returns the names of the variables of the expression (sorted, unique).
+func (e *Expression) Variables() []string {
+	v := map[string]bool{}
+	e.root.variables(v)
+
+	names := make([]string, 0, len(v))
+	for n := range v {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Linear returns true, if the expression is a linear combination of the variables, e.g. "a + 2 * b".
+// A product or a quotient of two variables isn't linear, e.g. "a * b" or "1 / a".
+// Only linear expressions keep the unit of the variables.
+func (e *Expression) Linear() bool {
+	return e.root.dim() >= 0
+}
+
+// Coefficients returns the coefficients of the variables of a linear expression, e.g. "a - 2 * b" >> a: 1, b: -2.
+// It returns nil, if the expression isn't linear.
+func (e *Expression) Coefficients() map[string]float64 {
+	if !e.Linear() {
+		return nil
+	}
+
+	c := map[string]float64{}
+	e.root.coefficients(c, 1)
+	return c
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.src
+}
+
+func (n number) eval(func(string) float64) float64        { return float64(n) }
+func (n number) dim() int                                 { return 0 }
+func (n number) variables(map[string]bool)                {}
+func (n number) coefficients(map[string]float64, float64) {}
+
+func (v variable) eval(value func(string) float64) float64           { return value(string(v)) }
+func (v variable) dim() int                                          { return 1 }
+func (v variable) variables(m map[string]bool)                       { m[string(v)] = true }
+func (v variable) coefficients(c map[string]float64, factor float64) { c[string(v)] += factor }
+
+func (u unary) eval(value func(string) float64) float64           { return -u.x.eval(value) }
+func (u unary) dim() int                                          { return u.x.dim() }
+func (u unary) variables(m map[string]bool)                       { u.x.variables(m) }
+func (u unary) coefficients(c map[string]float64, factor float64) { u.x.coefficients(c, -factor) }
+
+func (b binary) eval(value func(string) float64) float64 {
+	x, y := b.x.eval(value), b.y.eval(value)
+	switch b.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+func (b binary) dim() int {
+	x, y := b.x.dim(), b.y.dim()
+	switch {
+	case x < 0 || y < 0:
+		return -1
+	case b.op == '+' || b.op == '-':
+		return max(x, y)
+	case b.op == '*' && x+y <= 1:
+		return x + y
+	case b.op == '/' && y == 0:
+		return x
+	default:
+		return -1
+	}
+}
+
+func (b binary) variables(m map[string]bool) {
+	b.x.variables(m)
+	b.y.variables(m)
+}
+
+// coefficients of a linear term, one factor of a product and the divisor are constants.
+func (b binary) coefficients(c map[string]float64, factor float64) {
+	switch b.op {
+	case '+':
+		b.x.coefficients(c, factor)
+		b.y.coefficients(c, factor)
+	case '-':
+		b.x.coefficients(c, factor)
+		b.y.coefficients(c, -factor)
+	case '*':
+		if b.x.dim() == 0 {
+			b.y.coefficients(c, factor*constant(b.x))
+		} else {
+			b.x.coefficients(c, factor*constant(b.y))
+		}
+	default:
+		b.x.coefficients(c, factor/constant(b.y))
+	}
+}
+
+// constant returns the value of a term without variables.
+func constant(n node) float64 {
+	return n.eval(func(string) float64 { return 0 })
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// parser is a recursive descent parser, tok is the current token, it's empty at the end of the source.
+type parser struct {
+	src   string
+	pos   int
+	start int
+	tok   string
+}
+
+// expr = term { ("+" | "-") term }
+func (p *parser) expr() (node, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+// term = factor { ("*" | "/") factor }
+func (p *parser) term() (node, error) {
+	x, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok[0]
+		p.next()
+
+		y, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+// factor = number | variable | "(" expr ")" | "-" factor
+func (p *parser) factor() (node, error) {
+	tok := p.tok
+
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "-":
+		p.next()
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return unary{x: x}, nil
+	case tok == "(":
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at position %v", p.start+1)
+		}
+		p.next()
+		return x, nil
+	case tok[0] == '[':
+		name := strings.TrimSpace(strings.TrimSuffix(tok[1:], "]"))
+		if !strings.HasSuffix(tok, "]") || name == "" {
+			return nil, fmt.Errorf("invalid name %q at position %v", tok, p.start+1)
+		}
+		p.next()
+		return variable(name), nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %v", tok, p.start+1)
+		}
+		p.next()
+		return number(f), nil
+	case isLetter(rune(tok[0])):
+		p.next()
+		return variable(tok), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %v", tok, p.start+1)
+	}
+}
+
+// next reads the next token.
+func (p *parser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+
+	p.start = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	c := rune(p.src[p.pos])
+	switch {
+	case c == '[':
+		end := strings.IndexByte(p.src[p.pos:], ']')
+		if end < 0 {
+			// the unterminated name is reported by the parser
+			end = len(p.src) - p.pos - 1
+		}
+		p.pos += end + 1
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case isLetter(c):
+		for p.pos < len(p.src) && (isLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+
+	p.tok = p.src[p.start:p.pos]
+}
+
+// isLetter returns true for the ascii letters and the underscore, other names must be written in brackets.
+func isLetter(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
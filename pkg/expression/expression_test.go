@@ -0,0 +1,93 @@
+package expression
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	values := map[string]float64{"grid": 2, "pv": 3, "wallbox": 1.5, "heat-pump": 4, "a_1": 10}
+	value := func(name string) float64 { return values[name] }
+
+	for _, tc := range []struct {
+		src       string
+		want      float64
+		variables []string
+	}{
+		{"grid + pv - wallbox", 3.5, []string{"grid", "pv", "wallbox"}},
+		{"grid + pv * 2", 8, []string{"grid", "pv"}},
+		{"(grid + pv) * 2", 10, []string{"grid", "pv"}},
+		{"-grid - -pv", 1, []string{"grid", "pv"}},
+		{"[heat-pump] * 0.5", 2, []string{"heat-pump"}},
+		{"[ heat-pump ] / 4", 1, []string{"heat-pump"}},
+		{"a_1 / 4 / 2", 1.25, []string{"a_1"}},
+		{"grid + grid", 4, []string{"grid"}},
+		{" .5 * 4 ", 2, []string{}},
+	} {
+		e, err := Parse(tc.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.src, err)
+			continue
+		}
+		if got := e.Eval(value); got != tc.want {
+			t.Errorf("%q = %v, want %v", tc.src, got, tc.want)
+		}
+		if got := e.Variables(); !reflect.DeepEqual(got, tc.variables) {
+			t.Errorf("variables of %q = %v, want %v", tc.src, got, tc.variables)
+		}
+		if got := e.String(); got != tc.src {
+			t.Errorf("String() = %q, want %q", got, tc.src)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"grid +",
+		"grid pv",
+		"(grid + pv",
+		"grid + pv)",
+		"[heat-pump",
+		"[]",
+		"1.2.3",
+		"grid % 2",
+		"heat-pump * 2 +",
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestLinear(t *testing.T) {
+	for _, tc := range []struct {
+		src          string
+		linear       bool
+		coefficients map[string]float64
+	}{
+		{"a + b", true, map[string]float64{"a": 1, "b": 1}},
+		{"a - 2 * b", true, map[string]float64{"a": 1, "b": -2}},
+		{"-(a - b) * 0.5", true, map[string]float64{"a": -0.5, "b": 0.5}},
+		{"(a + b) / 4", true, map[string]float64{"a": 0.25, "b": 0.25}},
+		{"a - (1 - 2) * b", true, map[string]float64{"a": 1, "b": 1}},
+		{"a + a - a", true, map[string]float64{"a": 1}},
+		{"a + 10", true, map[string]float64{"a": 1}},
+		{"a * b", false, nil},
+		{"1 / a", false, nil},
+		{"a / (b + 1)", false, nil},
+		{"(a + b) * (a - b)", false, nil},
+		{"-(a * b) + c", false, nil},
+	} {
+		e, err := Parse(tc.src)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.src, err)
+		}
+		if got := e.Linear(); got != tc.linear {
+			t.Errorf("Linear(%q) = %v, want %v", tc.src, got, tc.linear)
+		}
+		if got := e.Coefficients(); !reflect.DeepEqual(got, tc.coefficients) {
+			t.Errorf("Coefficients(%q) = %v, want %v", tc.src, got, tc.coefficients)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package history provides an embedded time-series store of meter counters.
+//
+// The counters are stored in a bolt database, for each meter and each resolution in a separate bucket.
+// The key is the start of the interval (unix time in seconds, big endian), the value the last counter
+// of the interval. A downsampled point is the reading at the end of the interval, not a sum, so it's also
+// correct for counters which can decrease, e.g. the net counter of a bidirectional meter or a counter correction.
+// The intervals are aligned to the wall clock of the location of the store, e.g. a day starts at local midnight.
+package history
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rule defines a resolution of the stored counters and how long they are kept.
+type Rule struct {
+	Resolution time.Duration // interval of the stored counters
+	Retention  time.Duration // counters older than the retention are deleted, 0 keeps the counters forever
+}
+
+// Point is a counter at a point in time.
+type Point struct {
+	TimeStamp time.Time // start of the interval
+	Counter   float64   // last counter of the interval, eg kWh, l, m³
+}
+
+// Store is the time-series store.
+type Store struct {
+	db    *bolt.DB
+	rules []Rule
+	// loc is the location of the intervals
+	loc *time.Location
+	// maxPoints is the maximum number of points of a query, 0 >> unlimited
+	maxPoints int
+}
+
+// ErrUnknownMeter is returned by Query, if no counters of the meter are stored.
+var ErrUnknownMeter = errors.New("unknown meter")
+
+// ErrTooManyPoints is returned by Query, if the period contains more steps than the maximum number of points.
+var ErrTooManyPoints = errors.New("too many points")
+
+// Open opens the store, if the file doesn't exist, it's created.
+// The rules are sorted by resolution, the finest resolution is the first rule.
+// The intervals are aligned to the location loc, a query returns at most maxPoints points (0 >> unlimited).
+func Open(file string, rules []Rule, loc *time.Location, maxPoints int) (*Store, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("no history rules defined")
+	}
+
+	r := append([]Rule{}, rules...)
+	sort.Slice(r, func(i, j int) bool { return r[i].Resolution < r[j].Resolution })
+
+	db, err := bolt.Open(file, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db, rules: r, loc: loc, maxPoints: maxPoints}, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores the counter of the meter at time t in all resolutions.
+func (s *Store) Add(meter string, t time.Time, counter float64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		m, err := tx.CreateBucketIfNotExists([]byte(meter))
+		if err != nil {
+			return err
+		}
+
+		for _, r := range s.rules {
+			b, err := m.CreateBucketIfNotExists(bucketName(r.Resolution))
+			if err != nil {
+				return err
+			}
+
+			if err = b.Put(key(s.truncate(t, r.Resolution)), value(counter)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Prune deletes all counters which are older than the retention of the resolution.
+func (s *Store) Prune(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, m *bolt.Bucket) error {
+			for _, r := range s.rules {
+				b := m.Bucket(bucketName(r.Resolution))
+				if b == nil || r.Retention == 0 {
+					continue
+				}
+
+				limit := key(now.Add(-r.Retention))
+				c := b.Cursor()
+				for k, _ := c.First(); k != nil && string(k) < string(limit); k, _ = c.First() {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Query returns the counters of the meter between from and to (inclusive) with a resolution of step.
+// The counters are read from the coarsest stored resolution which isn't coarser than step and still contains from.
+// Each point contains the last counter of the step (steps are aligned to the location), steps without counters are skipped.
+// If the period contains more steps than the maximum number of points, ErrTooManyPoints is returned.
+func (s *Store) Query(meter string, from, to time.Time, step time.Duration) ([]Point, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("invalid step %v", step)
+	}
+	if n := to.Sub(from)/step + 1; s.maxPoints > 0 && n > time.Duration(s.maxPoints) {
+		return nil, fmt.Errorf("%w: %v steps of %v, maximum %v", ErrTooManyPoints, int64(n), step, s.maxPoints)
+	}
+
+	r := s.rule(from, step)
+	points := []Point{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		m := tx.Bucket([]byte(meter))
+		if m == nil {
+			return ErrUnknownMeter
+		}
+
+		b := m.Bucket(bucketName(r.Resolution))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		max := key(to)
+		for k, v := c.Seek(key(s.truncate(from, r.Resolution))); k != nil && string(k) <= string(max); k, v = c.Next() {
+			p := Point{
+				TimeStamp: s.truncate(time.Unix(int64(binary.BigEndian.Uint64(k)), 0), step),
+				Counter:   math.Float64frombits(binary.BigEndian.Uint64(v)),
+			}
+
+			// downsample to step, the last counter of the step wins
+			if n := len(points); n > 0 && points[n-1].TimeStamp.Equal(p.TimeStamp) {
+				points[n-1] = p
+				continue
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+
+	return points, err
+}
+
+// rule returns the rule with the coarsest resolution, which isn't coarser than step and still contains from.
+// If no such resolution exists, the finest resolution which contains from is used,
+// if no resolution contains from, the rule with the coarsest resolution is returned.
+func (s *Store) rule(from time.Time, step time.Duration) Rule {
+	best := -1
+	for i, r := range s.rules {
+		// a tolerance of a minute ensures that e.g. the last 24 hours are still read from a retention of one day
+		if r.Retention != 0 && time.Since(from) > r.Retention+time.Minute {
+			continue
+		}
+		if best == -1 || r.Resolution <= step {
+			best = i
+		}
+	}
+
+	if best >= 0 {
+		return s.rules[best]
+	}
+	return s.rules[len(s.rules)-1]
+}
+
+// truncate returns the start of the interval of time t, the intervals are aligned to the wall clock of the location,
+// e.g. an interval of 24h starts at midnight, an interval of 15m at a quarter of the hour.
+func (s *Store) truncate(t time.Time, d time.Duration) time.Time {
+	l := t.In(s.loc)
+	if d < 24*time.Hour {
+		// the offset at time t keeps the intervals unique, if the clock is set back at the end of daylight saving time
+		_, offset := l.Zone()
+		o := time.Duration(offset) * time.Second
+		return l.Add(o).Truncate(d).Add(-o)
+	}
+
+	u := time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC).Truncate(d)
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, s.loc)
+}
+
+func bucketName(resolution time.Duration) []byte {
+	return []byte(fmt.Sprintf("%ds", int64(resolution.Seconds())))
+}
+
+func key(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.Unix()))
+	return b
+}
+
+func value(f float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
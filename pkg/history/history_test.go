@@ -0,0 +1,86 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalIntervals(t *testing.T) {
+	loc := time.FixedZone("UTC+1", 3600)
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), []Rule{{Resolution: time.Hour}, {Resolution: 24 * time.Hour}}, loc, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the counter of a bidirectional meter decreases, the last counter of an interval wins
+	for _, p := range []struct {
+		t       time.Time
+		counter float64
+	}{
+		{time.Date(2026, 3, 10, 21, 10, 0, 0, time.UTC), 10},
+		{time.Date(2026, 3, 10, 22, 50, 0, 0, time.UTC), 12},
+		{time.Date(2026, 3, 10, 23, 10, 0, 0, time.UTC), 11},
+		{time.Date(2026, 3, 10, 23, 40, 0, 0, time.UTC), 9},
+	} {
+		if err = s.Add("grid", p.t, p.counter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := time.Date(2026, 3, 10, 0, 0, 0, 0, loc), time.Date(2026, 3, 12, 0, 0, 0, 0, loc)
+	points, err := s.Query("grid", from, to, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the days start at local midnight, 23:10 UTC is the next day in UTC+1
+	want := []Point{
+		{TimeStamp: time.Date(2026, 3, 10, 0, 0, 0, 0, loc), Counter: 12},
+		{TimeStamp: time.Date(2026, 3, 11, 0, 0, 0, 0, loc), Counter: 9},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+	for i := range want {
+		if !points[i].TimeStamp.Equal(want[i].TimeStamp) || points[i].Counter != want[i].Counter {
+			t.Errorf("point %v: got %v, want %v", i, points[i], want[i])
+		}
+	}
+
+	// the hours are read from the hourly resolution
+	if points, err = s.Query("grid", from, to, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[2].Counter != 9 || !points[2].TimeStamp.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, loc)) {
+		t.Errorf("hourly points %v, want 3 points, the last at local midnight with 9", points)
+	}
+}
+
+func TestMaxPoints(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), []Rule{{Resolution: time.Minute}}, time.UTC, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Add("water", time.Now(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	to := time.Now()
+	if _, err = s.Query("water", to.Add(-59*time.Minute), to, time.Minute); err != nil {
+		t.Errorf("query of 60 points: %v", err)
+	}
+	if _, err = s.Query("water", to.Add(-time.Hour), to, time.Minute); !errors.Is(err, ErrTooManyPoints) {
+		t.Errorf("query of 61 points: %v, want %v", err, ErrTooManyPoints)
+	}
+	if _, err = s.Query("water", to.Add(-24*time.Hour), to, time.Hour); err != nil {
+		t.Errorf("query of 25 hours: %v", err)
+	}
+	if _, err = s.Query("gas", to.Add(-time.Hour), to, time.Hour); !errors.Is(err, ErrUnknownMeter) {
+		t.Errorf("query of an unknown meter: %v, want %v", err, ErrUnknownMeter)
+	}
+}
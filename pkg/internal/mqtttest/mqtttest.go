@@ -0,0 +1,185 @@
+// Package mqtttest provides a mqtt broker stand-in for the tests of the mqtt handler and the application.
+package mqtttest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Message is a message, which has been published to the broker stand-in.
+type Message struct {
+	Topic    string
+	Qos      byte
+	Retained bool
+	Payload  []byte
+}
+
+// Broker is a broker stand-in, it accepts every mqtt connect, acknowledges the messages and subscriptions and answers pings.
+type Broker struct {
+	// URL is the connection string of the broker
+	URL string
+	// Clients receives the common names of the verified client certificates of each connect
+	Clients chan string
+	// Messages receives the published messages in the order of their arrival,
+	// if the channel is full, the messages are only kept for Last
+	Messages chan Message
+
+	sync.Mutex
+	// published are all published messages
+	published []Message
+}
+
+// Start starts a broker stand-in on a free port of the loopback interface, with a tls configuration, it's a tls broker.
+// The broker is stopped at the end of the test.
+func Start(t *testing.T, config *tls.Config) *Broker {
+	t.Helper()
+
+	var l net.Listener
+	var err error
+	scheme := "tcp://"
+	if config != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", config)
+		scheme = "ssl://"
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	b := &Broker{URL: scheme + l.Addr().String(), Clients: make(chan string, 10), Messages: make(chan Message, 1000)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+// serve answers the mqtt packets of a connection.
+func (b *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	cn := ""
+	if c, ok := conn.(*tls.Conn); ok {
+		if err := c.Handshake(); err != nil {
+			return
+		}
+		if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+			cn = certs[0].Subject.CommonName
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		// remaining length, variable byte integer
+		n, shift := 0, 0
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			n |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		body := make([]byte, n)
+		if _, err = io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT >> CONNACK accepted
+			select {
+			case b.Clients <- cn:
+			default:
+			}
+			_, _ = conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH >> PUBACK (qos 1) or PUBREC (qos 2)
+			l := int(binary.BigEndian.Uint16(body))
+			msg := Message{Topic: string(body[2 : 2+l]), Qos: header >> 1 & 0x03, Retained: header&0x01 != 0}
+			payload := body[2+l:]
+			switch msg.Qos {
+			case 1:
+				_, _ = conn.Write([]byte{0x40, 0x02, payload[0], payload[1]})
+				payload = payload[2:]
+			case 2:
+				_, _ = conn.Write([]byte{0x50, 0x02, payload[0], payload[1]})
+				payload = payload[2:]
+			}
+			msg.Payload = payload
+			b.publish(msg)
+		case 6: // PUBREL >> PUBCOMP
+			_, _ = conn.Write([]byte{0x70, 0x02, body[0], body[1]})
+		case 8: // SUBSCRIBE >> SUBACK, all subscriptions are granted with qos 0
+			ack := []byte{0x90, 0x02, body[0], body[1]}
+			for i := 2; i < len(body); {
+				i += 2 + int(binary.BigEndian.Uint16(body[i:])) + 1
+				ack = append(ack, 0x00)
+				ack[1]++
+			}
+			_, _ = conn.Write(ack)
+		case 12: // PINGREQ >> PINGRESP
+			_, _ = conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// publish keeps the published message and queues it to the channel Messages.
+func (b *Broker) publish(msg Message) {
+	b.Lock()
+	b.published = append(b.published, msg)
+	b.Unlock()
+
+	select {
+	case b.Messages <- msg:
+	default:
+	}
+}
+
+// Expect waits for the next published message.
+func (b *Broker) Expect(t *testing.T) Message {
+	t.Helper()
+
+	select {
+	case msg := <-b.Messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't receive a message")
+	}
+	return Message{}
+}
+
+// Last returns the last message published to the topic.
+func (b *Broker) Last(topic string) (Message, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	for i := len(b.published) - 1; i >= 0; i-- {
+		if b.published[i].Topic == topic {
+			return b.published[i], true
+		}
+	}
+	return Message{}, false
+}
@@ -2,25 +2,67 @@ package meter
 
 import (
 	"s0counter/pkg/app/config"
+	"s0counter/pkg/demand"
+	"s0counter/pkg/period"
 	"s0counter/pkg/raspberry"
 	"sync"
 	"time"
 )
 
 type S0 struct {
-	Tick          uint64    // s0 ticks overall
-	TimeStamp     time.Time // time of the last s0 pulse
-	LastTimeStamp time.Time // time of the penultimate s0 pulse
+	Tick          uint64                            // s0 ticks overall
+	TimeStamp     time.Time                         // time of the last s0 pulse
+	LastTimeStamp time.Time                         // time of the penultimate s0 pulse
+	PulseStart    time.Time                         // time of the active edge of the current s0 pulse (countmode pulse)
+	EdgeSeen      bool                              // true after the first edge (countmode pulse), a later release without active edge is rejected
+	Rejected      uint64                            // pulses rejected by the plausibility filter
+	Tariffs       map[string]uint64                 // s0 ticks per tariff
+	Periods       map[period.Period]period.Register // consumption registers of the calendar periods
+	Gauge         GaugeState                        // state of the gauge algorithm
+	Demand        demand.Register                   // demand register of the fixed or sliding windows
+}
+
+// GaugeState holds the state of the gauge algorithms of a register
+type GaugeState struct {
+	Pulses    []time.Time // times of the pulses of the sliding window (algorithm window)
+	Interval  float64     // smoothed pulse interval in seconds (algorithm ewma)
+	Rate      float64     // pulse rate of the last data collection interval in pulses per second (algorithm collection)
+	Tick      uint64      // ticks at the start of the data collection interval (algorithm collection)
+	TimeStamp time.Time   // start of the data collection interval (algorithm collection)
+}
+
+// Published holds the values of the last mqtt message, they are used by the publish policy
+type Published struct {
+	TimeStamp time.Time // time of the last published message
+	Counter   float64   // published counter, eg kWh, l, m³
+	Gauge     float64   // published gauge, e.g. kW, l/h, m³/h
+	Trailing  bool      // a trailing message is scheduled for a pulse within the mininterval (policy pulse)
+}
+
+// Exchange is an entry of the meter exchange history, the counter continues with the start reading of the new meter
+type Exchange struct {
+	TimeStamp  time.Time `yaml:"timestamp"`      // time of the exchange
+	OldReading float64   `yaml:"oldreading"`     // final reading of the replaced meter
+	NewReading float64   `yaml:"newreading"`     // start reading of the new meter
+	Counter    float64   `yaml:"counter"`        // counter before the exchange
+	Deviation  float64   `yaml:"deviation"`      // old reading - counter, e.g. missed pulses of the replaced meter
+	Note       string    `yaml:"note,omitempty"` // note, e.g. the serial number of the new meter
 }
 
 type Meter struct {
 	sync.RWMutex
-	LineHandler raspberry.Pin
-	Config      config.MeterConfig
+	LineHandler       raspberry.Pin
+	ExportLineHandler raspberry.Pin // export input of a bidirectional meter
+	Config            config.MeterConfig
 	//	TimeStamp   time.Time // timestamp of last gauge calculation
 	//	Counter     float64   // current counter (aktueller Zählerstand), eg kWh, l, m³
 	//	Gauge       float64   // mass flow rate per time unit  (= counter/t), e.g. kW, l/h, m³/h
-	S0 S0
+	S0        S0
+	Export    S0 // export register of a bidirectional meter, S0 is the import register
+	Published Published
+	Offset    *float64          // reading offset, the counter is offset + ticks / counterconstant, nil uses the configured offset
+	Exchanges []Exchange        // history of the meter exchanges
+	Operands  map[string]*Meter // meters referenced by the expression of a virtual meter
 }
 
 func New() map[string]*Meter {
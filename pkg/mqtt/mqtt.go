@@ -1,22 +1,63 @@
 package mqtt
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	mqttlib "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/womat/debug"
 )
 
 // quiesce is the specified number of milliseconds to wait for existing work to be completed.
+// publishTimeout is the time to wait for the completion of a synchronous publish.
+// replayInterval is the interval of the retries to publish the queued messages.
 const (
-	quiesce = 250
+	quiesce        = 250
+	publishTimeout = 2 * time.Second
+	replayInterval = 30 * time.Second
 )
 
+// errNotConnected is returned by Publish, if the broker isn't connected
+var errNotConnected = errors.New("mqtt broker isn't connected")
+
 // Handler contains the handler of the mqtt broker
 type Handler struct {
+	// published and failed count the published messages and the failed publish attempts
+	// they are the first fields to ensure the 64-bit alignment for atomic operations on 32-bit platforms
+	published uint64
+	failed    uint64
+
 	handler mqttlib.Client
 	// C is the channel to service the mqtt message
 	// sending a message to channel C will send the message
 	C chan Message
+
+	sync.Mutex
+	// subscriptions are subscribed again after every (re)connect
+	subscriptions map[string]subscription
+	// onConnect functions are called after every (re)connect
+	onConnect []func()
+	// will returns the message, which is published by the broker if the connection is lost,
+	// it's called before every (re)connect
+	will func() Message
+
+	// queue stores the not retained messages, which couldn't be published, it's nil if no queue is opened
+	queue *queue
+	// replay signals that the queued messages should be published
+	replay chan struct{}
+}
+
+// subscription contains the properties of a subscribed topic
+type subscription struct {
+	qos      byte
+	callback func(Message)
 }
 
 // Message contains the properties of the mqtt message
@@ -25,27 +66,126 @@ type Message struct {
 	Payload  []byte
 	Qos      byte
 	Retained bool
+	// TimeStamp is the time of the values of the payload, it's kept with a queued message (zero: time of the queuing)
+	TimeStamp time.Time
+}
+
+// Options contains the connection properties of the mqtt broker
+type Options struct {
+	Broker             string        // connection string, e.g. tcp://127.0.0.1:1883, ssl://127.0.0.1:8883
+	Username           string        // user of the authentication, if it's empty, no authentication is used
+	Password           string        // password of the authentication
+	ClientID           string        // client id, if it's empty, the broker assigns a client id
+	CAFile             string        // pem file of the certificate authorities of the broker certificate
+	CertFile           string        // pem file of the client certificate, e.g. for authentication with certificates
+	KeyFile            string        // pem file of the key of the client certificate
+	InsecureSkipVerify bool          // if it's true, the broker certificate isn't verified
+	KeepAlive          time.Duration // interval of the keep alive messages, 0 uses the default of the client (30s)
 }
 
 // New generate a new mqtt broker client
 func New() *Handler {
 	return &Handler{
-		C: make(chan Message),
+		C:             make(chan Message),
+		subscriptions: map[string]subscription{},
+		replay:        make(chan struct{}, 1),
 	}
 }
 
 // Connect connects to the mqtt broker
 // if no broker is defined, mo mqtt message are send
-func (m *Handler) Connect(broker string) error {
-	if broker == "" {
+func (m *Handler) Connect(o Options) error {
+	if o.Broker == "" {
 		return nil
 	}
 
-	opts := mqttlib.NewClientOptions().AddBroker(broker)
+	opts := mqttlib.NewClientOptions().AddBroker(o.Broker)
+	opts.SetClientID(o.ClientID)
+	opts.SetUsername(o.Username)
+	opts.SetPassword(o.Password)
+	if o.KeepAlive > 0 {
+		opts.SetKeepAlive(o.KeepAlive)
+	}
+
+	tlsConfig, err := newTLSConfig(o)
+	if err != nil {
+		return err
+	}
+	opts.SetTLSConfig(tlsConfig)
+
+	opts.SetOnConnectHandler(m.connected)
+	if m.will != nil {
+		w := m.will()
+		opts.SetBinaryWill(w.Topic, w.Payload, w.Qos, w.Retained)
+		opts.SetReconnectingHandler(func(_ mqttlib.Client, o *mqttlib.ClientOptions) {
+			w := m.will()
+			o.SetBinaryWill(w.Topic, w.Payload, w.Qos, w.Retained)
+		})
+	}
 	m.handler = mqttlib.NewClient(opts)
 	return m.ReConnect()
 }
 
+// newTLSConfig returns the tls configuration of the certificate files.
+func newTLSConfig(o Options) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in cafile %v", o.CAFile)
+		}
+	}
+
+	switch {
+	case o.CertFile != "" && o.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	case o.CertFile != "" || o.KeyFile != "":
+		return nil, errors.New("a client certificate needs a certfile and a keyfile")
+	}
+
+	return c, nil
+}
+
+// OpenQueue opens the persistent queue of the not retained messages, which couldn't be published.
+// The queued messages are published in the original order after the connection is restored.
+// If the queue contains size messages, the oldest message is dropped (dropOldest) or the new message.
+// It must be called before Service.
+func (m *Handler) OpenQueue(file string, size int, dropOldest bool) (err error) {
+	m.queue, err = openQueue(file, size, dropOldest)
+	return
+}
+
+// QueueDepth returns the number of queued messages.
+func (m *Handler) QueueDepth() int {
+	if m.queue == nil {
+		return 0
+	}
+	return m.queue.len()
+}
+
+// SetWill defines the last will, which is published by the broker if the connection is lost unexpectedly.
+// It must be called before Connect.
+func (m *Handler) SetWill(msg Message) {
+	m.SetWillFunc(func() Message { return msg })
+}
+
+// SetWillFunc defines a last will, which is created before every (re)connect,
+// e.g. if the will contains a sequence number of the connection.
+// It must be called before Connect.
+func (m *Handler) SetWillFunc(f func() Message) {
+	m.will = f
+}
+
 // ReConnect reconnects to the defined mqtt broker
 func (m *Handler) ReConnect() error {
 	t := m.handler.Connect()
@@ -60,38 +200,190 @@ func (m *Handler) Disconnect() error {
 	}
 
 	m.handler.Disconnect(quiesce)
+
+	if m.queue != nil {
+		return m.queue.close()
+	}
 	return nil
 }
 
 // Service listens to a message on the channel C and sends the message
-// if no handler or topic is defined, the message will be ignored
+// if no handler or topic is defined, the message will be ignored.
+// The messages are published one after the other in the order of channel C,
+// if the broker isn't connected, the client reconnects automatically and the messages are queued meanwhile.
 func (m *Handler) Service() {
+	if m.handler != nil && m.queue != nil {
+		go m.replayQueue()
+	}
+
 	for d := range m.C {
 		if m.handler == nil || d.Topic == "" {
 			continue
 		}
 
-		go func(msg Message) {
-			if !m.handler.IsConnected() {
-				debug.DebugLog.Printf("mqtt broker isn't connected, reconnect it")
+		// while messages are queued, new messages are queued too, to keep the order of the messages
+		if m.queue != nil && !d.Retained && (!m.handler.IsConnectionOpen() || m.queue.len() > 0) {
+			m.enqueue(d)
+			continue
+		}
+
+		debug.DebugLog.Printf("publishing %v bytes to topic %v", len(d.Payload), d.Topic)
+		if err := m.Publish(d); err != nil {
+			debug.ErrorLog.Printf("publishing topic %v: %v", d.Topic, err)
+			m.enqueue(d)
+		}
+	}
+}
+
+// enqueue stores a not retained message in the queue, retained messages are dropped,
+// because they are replaced by the next state update anyway.
+func (m *Handler) enqueue(msg Message) {
+	if m.queue == nil || msg.Retained {
+		return
+	}
+
+	if err := m.queue.push(msg); err != nil {
+		debug.ErrorLog.Printf("can't queue message of topic %v: %v", msg.Topic, err)
+		return
+	}
+
+	debug.DebugLog.Printf("queued message of topic %v, queue depth %v", msg.Topic, m.queue.len())
+	m.signalReplay()
+}
+
+// signalReplay triggers the replay of the queued messages.
+func (m *Handler) signalReplay() {
+	select {
+	case m.replay <- struct{}{}:
+	default:
+	}
+}
+
+// replayQueue publishes the queued messages in the original order, if the broker is connected.
+// The replay is triggered by each (re)connect and each queued message, and retried periodically.
+func (m *Handler) replayQueue() {
+	retry := time.NewTicker(replayInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-m.replay:
+		case <-retry.C:
+		}
+
+		for m.handler.IsConnectionOpen() {
+			key, msg, ok, err := m.queue.peek()
+			if err != nil {
+				debug.ErrorLog.Printf("can't read queued message: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+
+			debug.DebugLog.Printf("replay message of topic %v of %v", msg.Topic, msg.TimeStamp)
+			if err = m.Publish(Message{Topic: msg.Topic, Payload: msg.Payload, Qos: msg.Qos, TimeStamp: msg.TimeStamp}); err != nil {
+				debug.ErrorLog.Printf("can't replay message of topic %v: %v", msg.Topic, err)
+				break
+			}
+
+			if err = m.queue.remove(key); err != nil {
+				debug.ErrorLog.Printf("can't remove replayed message: %v", err)
+				break
+			}
+		}
+	}
+}
+
+// Publish sends the message and waits until it's published.
+// It's used if the message must be sent before the connection is closed, e.g. at shutdown.
+// If the broker isn't connected, errNotConnected is returned, so the caller can keep the message.
+func (m *Handler) Publish(msg Message) error {
+	if m.handler == nil || msg.Topic == "" {
+		return nil
+	}
+	if !m.handler.IsConnectionOpen() {
+		atomic.AddUint64(&m.failed, 1)
+		return errNotConnected
+	}
+
+	t := m.handler.Publish(msg.Topic, msg.Qos, msg.Retained, msg.Payload)
+	if !t.WaitTimeout(publishTimeout) {
+		atomic.AddUint64(&m.failed, 1)
+		return fmt.Errorf("publishing topic %v: timeout", msg.Topic)
+	}
+	if err := t.Error(); err != nil {
+		atomic.AddUint64(&m.failed, 1)
+		return err
+	}
+
+	atomic.AddUint64(&m.published, 1)
+	return nil
+}
+
+// Stats returns the number of published messages and failed publish attempts.
+func (m *Handler) Stats() (published, failed uint64) {
+	return atomic.LoadUint64(&m.published), atomic.LoadUint64(&m.failed)
+}
+
+// OnConnect registers a function, which is called after every (re)connect to the broker.
+func (m *Handler) OnConnect(f func()) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.onConnect = append(m.onConnect, f)
+}
+
+// Subscribe subscribes the topic, the callback is called for every received message.
+// The subscription is restored after every reconnect.
+// If no broker is defined, the subscription is ignored.
+func (m *Handler) Subscribe(topic string, qos byte, callback func(Message)) error {
+	m.Lock()
+	m.subscriptions[topic] = subscription{qos: qos, callback: callback}
+	m.Unlock()
+
+	if m.handler == nil || !m.handler.IsConnected() {
+		return nil
+	}
+
+	t := m.handler.Subscribe(topic, qos, messageHandler(callback))
+	<-t.Done()
+	return t.Error()
+}
+
+// connected restores the subscriptions and calls the onConnect functions after a (re)connect.
+// It's called by the mqtt client and mustn't block.
+func (m *Handler) connected(c mqttlib.Client) {
+	debug.InfoLog.Print("connected to mqtt broker")
 
-				if err := m.ReConnect(); err != nil {
-					debug.ErrorLog.Printf("can't reconnect to mqtt broker %v", err)
-					return
-				}
+	m.Lock()
+	defer m.Unlock()
+
+	for topic, s := range m.subscriptions {
+		go func(topic string, s subscription) {
+			t := c.Subscribe(topic, s.qos, messageHandler(s.callback))
+			<-t.Done()
+			if err := t.Error(); err != nil {
+				debug.ErrorLog.Printf("subscribing topic %v: %v", topic, err)
 			}
+		}(topic, s)
+	}
+
+	for _, f := range m.onConnect {
+		go f()
+	}
+
+	m.signalReplay()
+}
 
-			debug.DebugLog.Printf("publishing %v bytes to topic %v", len(msg.Payload), msg.Topic)
-			t := m.handler.Publish(msg.Topic, msg.Qos, msg.Retained, msg.Payload)
-
-			// the asynchronous nature of this library makes it easy to forget to check for errors.
-			// Consider using a go routine to log these
-			go func() {
-				<-t.Done()
-				if err := t.Error(); err != nil {
-					debug.ErrorLog.Printf("publishing topic %v: %v", msg.Topic, err)
-				}
-			}()
-		}(d)
+// messageHandler converts a received message of the mqtt client to a Message.
+func messageHandler(callback func(Message)) mqttlib.MessageHandler {
+	return func(_ mqttlib.Client, msg mqttlib.Message) {
+		callback(Message{
+			Topic:    msg.Topic(),
+			Payload:  msg.Payload(),
+			Qos:      msg.Qos(),
+			Retained: msg.Retained(),
+		})
 	}
 }
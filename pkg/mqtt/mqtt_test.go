@@ -0,0 +1,281 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"s0counter/pkg/internal/mqtttest"
+	"strconv"
+	"testing"
+	"time"
+
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+)
+
+// testCert is a generated certificate with its pem files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	tls      tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+// newTestCert generates a certificate signed by the parent, without parent the certificate is a self-signed ca.
+func newTestCert(t *testing.T, dir, name string, parent *testCert, client bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	signer, signerKey := tmpl, key
+	switch {
+	case parent == nil:
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	case client:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		signer, signerKey = parent.cert, parent.key
+	default:
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".crt"), keyFile: filepath.Join(dir, name+".key")}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = os.WriteFile(c.certFile, certPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(c.keyFile, keyPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c.tls, err = tls.X509KeyPair(certPem, keyPem); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testPKI is a private ca with a broker and a client certificate.
+type testPKI struct {
+	ca, server, client *testCert
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, false)
+	return testPKI{
+		ca:     ca,
+		server: newTestCert(t, dir, "broker", ca, false),
+		client: newTestCert(t, dir, "s0counter", ca, true),
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+
+	c, err := newTLSConfig(Options{CAFile: pki.ca.certFile, CertFile: pki.client.certFile, KeyFile: pki.client.keyFile})
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if c.RootCAs == nil || len(c.Certificates) != 1 || c.InsecureSkipVerify {
+		t.Errorf("unexpected tls config: root cas %v, certificates %v, insecure %v", c.RootCAs != nil, len(c.Certificates), c.InsecureSkipVerify)
+	}
+
+	c, err = newTLSConfig(Options{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if c.RootCAs != nil || len(c.Certificates) != 0 || !c.InsecureSkipVerify {
+		t.Errorf("unexpected tls config: root cas %v, certificates %v, insecure %v", c.RootCAs != nil, len(c.Certificates), c.InsecureSkipVerify)
+	}
+
+	invalid := map[string]Options{
+		"missing cafile":       {CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+		"cafile without cert":  {CAFile: pki.client.keyFile},
+		"certfile without key": {CertFile: pki.client.certFile},
+		"keyfile without cert": {KeyFile: pki.client.keyFile},
+		"key of another cert":  {CertFile: pki.client.certFile, KeyFile: pki.server.keyFile},
+	}
+	for name, o := range invalid {
+		if _, err := newTLSConfig(o); err == nil {
+			t.Errorf("%v: newTLSConfig doesn't fail", name)
+		}
+	}
+}
+
+func TestConnectMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(pki.ca.cert)
+	broker := mqtttest.Start(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.server.tls},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	m := New()
+	if err := m.Connect(Options{Broker: broker.URL, ClientID: "test", CAFile: pki.ca.certFile, CertFile: pki.client.certFile, KeyFile: pki.client.keyFile}); err != nil {
+		t.Fatalf("connect with client certificate: %v", err)
+	}
+	defer m.Disconnect()
+
+	select {
+	case cn := <-broker.Clients:
+		if cn != "s0counter" {
+			t.Errorf("client certificate %q, want s0counter", cn)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't receive connect")
+	}
+
+	// without client certificate, the broker refuses the handshake
+	m = New()
+	if err := m.Connect(Options{Broker: broker.URL, ClientID: "test", CAFile: pki.ca.certFile}); err == nil {
+		_ = m.Disconnect()
+		t.Error("connect without client certificate doesn't fail")
+	}
+}
+
+func TestConnectInsecureSkipVerify(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := mqtttest.Start(t, &tls.Config{Certificates: []tls.Certificate{pki.server.tls}})
+
+	// the broker certificate is signed by an unknown ca
+	m := New()
+	if err := m.Connect(Options{Broker: broker.URL, ClientID: "test"}); err == nil {
+		_ = m.Disconnect()
+		t.Error("connect to broker with unknown ca doesn't fail")
+	}
+
+	m = New()
+	if err := m.Connect(Options{Broker: broker.URL, ClientID: "test", InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("connect with insecureskipverify: %v", err)
+	}
+	defer m.Disconnect()
+
+	select {
+	case <-broker.Clients:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't receive connect")
+	}
+}
+
+func TestPublishNotConnected(t *testing.T) {
+	m := New()
+	m.handler = mqttlib.NewClient(mqttlib.NewClientOptions().AddBroker("tcp://127.0.0.1:1"))
+
+	if err := m.Publish(Message{Topic: "test", Payload: []byte("1")}); err != errNotConnected {
+		t.Errorf("Publish = %v, want %v", err, errNotConnected)
+	}
+	if _, failed := m.Stats(); failed != 1 {
+		t.Errorf("%v failed messages, want 1", failed)
+	}
+}
+
+func TestServiceOrder(t *testing.T) {
+	broker := mqtttest.Start(t, nil)
+
+	m := New()
+	if err := m.Connect(Options{Broker: broker.URL, ClientID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Disconnect()
+	go m.Service()
+
+	const n = 200
+	go func() {
+		for i := 0; i < n; i++ {
+			m.C <- Message{Topic: "test", Payload: []byte(strconv.Itoa(i)), Qos: byte(i % 2)}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		if got := string(broker.Expect(t).Payload); got != strconv.Itoa(i) {
+			t.Fatalf("message %v received at position %v", got, i)
+		}
+	}
+}
+
+func TestReplayQueue(t *testing.T) {
+	broker := mqtttest.Start(t, nil)
+
+	m := New()
+	if err := m.OpenQueue(filepath.Join(t.TempDir(), "queue.db"), 10, true); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Disconnect()
+
+	// the messages, which couldn't be published during an outage
+	t0 := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := m.queue.push(Message{Topic: "test", Payload: []byte(strconv.Itoa(i)), Qos: 1, TimeStamp: t0.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without connection, the replay stops and the messages are kept
+	m.handler = mqttlib.NewClient(mqttlib.NewClientOptions().AddBroker(broker.URL))
+	_, msg, _, err := m.queue.peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Publish(Message{Topic: msg.Topic, Payload: msg.Payload, Qos: msg.Qos}); err == nil {
+		t.Fatal("publish without connection doesn't fail")
+	}
+	if !msg.TimeStamp.Equal(t0) {
+		t.Errorf("queued message of %v, want %v", msg.TimeStamp, t0)
+	}
+	if d := m.QueueDepth(); d != 3 {
+		t.Errorf("queue depth %v, want 3", d)
+	}
+
+	// the connect triggers the replay, new messages are published after the queued messages
+	if err = m.Connect(Options{Broker: broker.URL, ClientID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	go m.Service()
+	m.C <- Message{Topic: "test", Payload: []byte("3")}
+
+	for i := 0; i < 4; i++ {
+		if got := string(broker.Expect(t).Payload); got != strconv.Itoa(i) {
+			t.Fatalf("message %v received at position %v", got, i)
+		}
+	}
+	if d := m.QueueDepth(); d != 0 {
+		t.Errorf("queue depth %v after the replay, want 0", d)
+	}
+}
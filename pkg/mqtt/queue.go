@@ -0,0 +1,160 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// queueBucket is the bolt bucket of the queued messages, the key is a sequence number (big endian)
+var queueBucket = []byte("queue")
+
+// errQueueFull is returned by push, if the queue is full and the newest message is dropped
+var errQueueFull = errors.New("queue is full")
+
+// queue is a bounded persistent fifo queue of the messages, which couldn't be published.
+type queue struct {
+	sync.Mutex
+	db *bolt.DB
+	// n is the number of queued messages
+	n int
+	// size is the maximum number of queued messages
+	size int
+	// dropOldest defines the drop policy of a full queue, true drops the oldest message, false the newest message
+	dropOldest bool
+}
+
+// queuedMessage is a queued message with the time of its values.
+type queuedMessage struct {
+	TimeStamp time.Time
+	Topic     string
+	Payload   []byte
+	Qos       byte
+}
+
+// openQueue opens the queue, if the file doesn't exist, it's created.
+func openQueue(file string, size int, dropOldest bool) (*queue, error) {
+	db, err := bolt.Open(file, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	q := queue{db: db, size: size, dropOldest: dropOldest}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(queueBucket)
+		if err != nil {
+			return err
+		}
+		q.n = b.Stats().KeyN
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &q, nil
+}
+
+// close closes the queue, the queued messages are kept.
+func (q *queue) close() error {
+	return q.db.Close()
+}
+
+// push appends the message to the queue.
+// If the queue is full, the oldest message is dropped or errQueueFull is returned (drop policy newest).
+func (q *queue) push(msg Message) error {
+	t := msg.TimeStamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	v, err := json.Marshal(queuedMessage{TimeStamp: t, Topic: msg.Topic, Payload: msg.Payload, Qos: msg.Qos})
+	if err != nil {
+		return err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	n := q.n
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+
+		if n >= q.size {
+			if !q.dropOldest {
+				return errQueueFull
+			}
+
+			if k, _ := b.Cursor().First(); k != nil {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+				n--
+			}
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, seq)
+		return b.Put(k, v)
+	})
+
+	if err == nil {
+		q.n = n + 1
+	}
+	return err
+}
+
+// peek returns the oldest message and its key, ok is false if the queue is empty.
+func (q *queue) peek() (key []byte, msg queuedMessage, ok bool, err error) {
+	err = q.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(queueBucket).Cursor().First()
+		if k == nil {
+			return nil
+		}
+
+		ok = true
+		key = append([]byte{}, k...)
+		return json.Unmarshal(v, &msg)
+	})
+	return
+}
+
+// remove deletes the message with the key.
+func (q *queue) remove(key []byte) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// the message may have been dropped in the meantime
+	deleted := false
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		if b.Get(key) == nil {
+			return nil
+		}
+
+		deleted = true
+		return b.Delete(key)
+	})
+
+	if err == nil && deleted {
+		q.n--
+	}
+	return err
+}
+
+// len returns the number of queued messages.
+func (q *queue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.n
+}
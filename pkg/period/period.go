@@ -0,0 +1,81 @@
+// Package period provides consumption registers of calendar periods (hour, day, week, month, year).
+package period
+
+import "time"
+
+// Period is a calendar period.
+type Period string
+
+const (
+	Hour  Period = "hour"
+	Day   Period = "day"
+	Week  Period = "week"
+	Month Period = "month"
+	Year  Period = "year"
+)
+
+// All contains all supported periods.
+var All = []Period{Hour, Day, Week, Month, Year}
+
+// Start returns the start of the period which contains t, in the location of t.
+// A week starts on monday (ISO 8601).
+func (p Period) Start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+
+	switch p {
+	case Hour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case Week:
+		// days since monday
+		wd := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-wd, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case Year:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+}
+
+// Register holds the ticks of the current and the previous period.
+type Register struct {
+	Start      time.Time `yaml:"start"`      // start of the current period
+	StartTicks uint64    `yaml:"startticks"` // s0 ticks at the start of the current period
+	LastStart  time.Time `yaml:"laststart"`  // start of the previous period
+	LastTicks  uint64    `yaml:"lastticks"`  // s0 ticks of the previous period
+}
+
+// Update closes the current period, if time t is in a new period.
+// If the periods between the current period and the period of t weren't registered
+// (e.g. the application was stopped), the ticks of the previous period are 0.
+// Update returns true, if the current period has been closed.
+func (r *Register) Update(p Period, t time.Time, ticks uint64) bool {
+	s := p.Start(t)
+
+	switch {
+	case r.Start.IsZero():
+		r.Start, r.StartTicks = s, ticks
+		return false
+	case !s.After(r.Start):
+		return false
+	}
+
+	prev := p.Start(s.Add(-time.Nanosecond))
+	r.LastStart, r.LastTicks = prev, 0
+	if prev.Equal(r.Start) && ticks >= r.StartTicks {
+		r.LastTicks = ticks - r.StartTicks
+	}
+
+	r.Start, r.StartTicks = s, ticks
+	return true
+}
+
+// Ticks returns the s0 ticks of the current period.
+func (r *Register) Ticks(ticks uint64) uint64 {
+	if ticks < r.StartTicks {
+		return 0
+	}
+	return ticks - r.StartTicks
+}
@@ -0,0 +1,314 @@
+// Package pulselog provides a compact append-only file format to record s0 pulses.
+//
+// The file starts with the header "S0PULSE1", followed by records.
+// Each record starts with a type byte:
+//  'M' defines a meter: uvarint id, varint pin, uvarint length of name, name
+//  'C' defines a meter of a gpio chip: like 'M', followed by uvarint length of chip, chip
+//  'P' is a pulse: uvarint id of meter, varint nanoseconds since the previous pulse
+//      (the first pulse contains the nanoseconds since 1970-01-01 UTC)
+package pulselog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const header = "S0PULSE1"
+
+const (
+	typeMeter     = 'M'
+	typeChipMeter = 'C'
+	typePulse     = 'P'
+)
+
+// Record is a recorded s0 pulse.
+type Record struct {
+	Meter     string    // name of the meter
+	Chip      string    // gpio chip of the pin, empty for the default chip
+	Pin       int       // gpio pin of the meter
+	TimeStamp time.Time // time of the pulse
+}
+
+type meterDef struct {
+	name string
+	chip string
+	pin  int
+}
+
+// Writer appends pulses to a recording.
+type Writer struct {
+	sync.Mutex
+	file *os.File
+	ids  map[meterDef]uint64
+	last int64
+}
+
+// Create opens the recording for appending, if the file doesn't exist, it's created.
+// An incomplete record at the end of the file (e.g. after a power failure) is removed.
+func Create(name string) (*Writer, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{file: f, ids: map[meterDef]uint64{}}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		if _, err = f.Write([]byte(header)); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	// read the existing records to continue the meter ids and the timestamps
+	r, err := newReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	for {
+		if _, err = r.Read(); err != nil {
+			break
+		}
+	}
+	if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		_ = f.Close()
+		return nil, err
+	}
+
+	for id, m := range r.meters {
+		// meters defined by the incomplete record are removed by truncating the file
+		if !r.pending[id] {
+			w.ids[m] = id
+		}
+	}
+	w.last = r.last
+
+	if err = f.Truncate(r.offset); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(r.offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write appends a pulse to the recording.
+func (w *Writer) Write(rec Record) error {
+	w.Lock()
+	defer w.Unlock()
+
+	var b bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	m := meterDef{name: rec.Meter, chip: rec.Chip, pin: rec.Pin}
+	id, ok := w.ids[m]
+	if !ok {
+		id = uint64(len(w.ids))
+		// meters of the default chip keep the record of the first version
+		if m.chip == "" {
+			b.WriteByte(typeMeter)
+		} else {
+			b.WriteByte(typeChipMeter)
+		}
+		b.Write(buf[:binary.PutUvarint(buf, id)])
+		b.Write(buf[:binary.PutVarint(buf, int64(m.pin))])
+		b.Write(buf[:binary.PutUvarint(buf, uint64(len(m.name)))])
+		b.WriteString(m.name)
+		if m.chip != "" {
+			b.Write(buf[:binary.PutUvarint(buf, uint64(len(m.chip)))])
+			b.WriteString(m.chip)
+		}
+	}
+
+	t := rec.TimeStamp.UnixNano()
+	b.WriteByte(typePulse)
+	b.Write(buf[:binary.PutUvarint(buf, id)])
+	b.Write(buf[:binary.PutVarint(buf, t-w.last)])
+
+	if _, err := w.file.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	w.ids[m] = id
+	w.last = t
+	return nil
+}
+
+// Close closes the recording.
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.file.Close()
+}
+
+// Reader reads the pulses of a recording.
+type Reader struct {
+	file   *os.File
+	r      *bufio.Reader
+	meters map[uint64]meterDef
+	// pending are the meters defined after the last complete pulse
+	pending map[uint64]bool
+	last    int64
+	// offset is the file offset after the last complete record
+	offset int64
+}
+
+// Open opens a recording for reading.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newReader(f *os.File) (*Reader, error) {
+	r := &Reader{file: f, r: bufio.NewReader(f), meters: map[uint64]meterDef{}, pending: map[uint64]bool{}}
+
+	h := make([]byte, len(header))
+	if _, err := io.ReadFull(r.r, h); err != nil || string(h) != header {
+		return nil, fmt.Errorf("%v isn't a pulse recording", f.Name())
+	}
+
+	r.offset = int64(len(header))
+	return r, nil
+}
+
+// Read returns the next pulse of the recording.
+// At the end of the recording, Read returns io.EOF.
+// If the last record is incomplete, Read returns io.ErrUnexpectedEOF.
+func (r *Reader) Read() (Record, error) {
+	c := &countingReader{r: r.r}
+
+	for {
+		typ, err := c.ReadByte()
+		if err != nil {
+			return Record{}, err
+		}
+
+		switch typ {
+		case typeMeter, typeChipMeter:
+			var m meterDef
+			id, err := binary.ReadUvarint(c)
+			if err != nil {
+				return Record{}, unexpected(err)
+			}
+			pin, err := binary.ReadVarint(c)
+			if err != nil {
+				return Record{}, unexpected(err)
+			}
+			l, err := binary.ReadUvarint(c)
+			if err != nil {
+				return Record{}, unexpected(err)
+			}
+			name := make([]byte, l)
+			if _, err = io.ReadFull(c, name); err != nil {
+				return Record{}, unexpected(err)
+			}
+
+			m.name, m.pin = string(name), int(pin)
+			if typ == typeChipMeter {
+				if m.chip, err = readString(c); err != nil {
+					return Record{}, unexpected(err)
+				}
+			}
+			r.meters[id] = m
+			r.pending[id] = true
+
+		case typePulse:
+			id, err := binary.ReadUvarint(c)
+			if err != nil {
+				return Record{}, unexpected(err)
+			}
+			dt, err := binary.ReadVarint(c)
+			if err != nil {
+				return Record{}, unexpected(err)
+			}
+
+			m, ok := r.meters[id]
+			if !ok {
+				return Record{}, fmt.Errorf("pulse of undefined meter id %v", id)
+			}
+
+			r.last += dt
+			r.offset += c.n
+			r.pending = map[uint64]bool{}
+			return Record{Meter: m.name, Chip: m.chip, Pin: m.pin, TimeStamp: time.Unix(0, r.last)}, nil
+
+		default:
+			return Record{}, fmt.Errorf("invalid record type %q", typ)
+		}
+	}
+}
+
+// Close closes the recording.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// readString reads a string with its uvarint length.
+func readString(c *countingReader) (string, error) {
+	l, err := binary.ReadUvarint(c)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, l)
+	if _, err = io.ReadFull(c, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// unexpected converts an io.EOF within a record into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,163 @@
+package pulselog
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	start := time.Date(2026, 3, 10, 10, 15, 0, 123456789, time.UTC)
+	records := []Record{
+		{Meter: "wallbox", Pin: 17, TimeStamp: start},
+		{Meter: "heat pump", Pin: 27, TimeStamp: start.Add(time.Millisecond)},
+		{Meter: "wallbox", Pin: 17, TimeStamp: start.Add(2 * time.Second)},
+		// the clock can be set back, the intervals are signed
+		{Meter: "heat pump", Pin: 27, TimeStamp: start.Add(-time.Hour)},
+		{Meter: "grid", Pin: -1, TimeStamp: start.Add(48 * time.Hour)},
+		// the same pin on another chip is another meter
+		{Meter: "pv", Chip: "gpiochip4", Pin: 17, TimeStamp: start.Add(49 * time.Hour)},
+		{Meter: "wallbox", Pin: 17, TimeStamp: start.Add(50 * time.Hour)},
+	}
+
+	w, err := Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records[:3] {
+		if err = w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// appending continues the meter ids and the timestamps of the existing recording
+	if w, err = Create(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records[3:] {
+		if err = w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, file)
+	if len(got) != len(records) {
+		t.Fatalf("%v records, want %v", len(got), len(records))
+	}
+	for i, rec := range records {
+		if got[i].Meter != rec.Meter || got[i].Chip != rec.Chip || got[i].Pin != rec.Pin || !got[i].TimeStamp.Equal(rec.TimeStamp) {
+			t.Errorf("record %v: got %+v, want %+v", i, got[i], rec)
+		}
+	}
+}
+
+func TestIncompleteRecord(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	start := time.Date(2026, 3, 10, 10, 15, 0, 0, time.UTC)
+
+	w, err := Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(Record{Meter: "wallbox", Pin: 17, TimeStamp: start}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a power failure while the definition of a new meter and its pulse is written
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{typeMeter, 1, 2, 4, 'g', 'r'}); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	r, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Read of the incomplete record = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	_ = r.Close()
+
+	// the incomplete record is removed, the undefined meter gets the next id again
+	if w, err = Create(file); err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated.Size() != fi.Size() {
+		t.Errorf("size after truncating %v, want %v", truncated.Size(), fi.Size())
+	}
+	if err = w.Write(Record{Meter: "grid", Pin: 18, TimeStamp: start.Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, file)
+	if len(got) != 2 || got[1].Meter != "grid" || got[1].Pin != 18 || !got[1].TimeStamp.Equal(start.Add(time.Second)) {
+		t.Errorf("got %+v, want wallbox and grid", got)
+	}
+}
+
+func TestInvalidHeader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	if err := os.WriteFile(file, []byte("S0PULSE0"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(file); err == nil {
+		t.Error("Open of a file with an invalid header succeeded")
+	}
+	if _, err := Create(file); err == nil {
+		t.Error("Create of a file with an invalid header succeeded")
+	}
+}
+
+// readAll returns all records of the recording.
+func readAll(t *testing.T, file string) []Record {
+	t.Helper()
+
+	r, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var records []Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
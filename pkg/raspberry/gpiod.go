@@ -0,0 +1,294 @@
+//+build linux
+
+package raspberry
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/warthog618/gpiod"
+	"github.com/womat/debug"
+)
+
+// consumer is the label of the requested lines, it's shown e.g. by gpioinfo
+const consumer = "s0counter"
+
+type GpiodPin struct {
+	sync.Mutex
+	chip   *gpiod.Chip
+	offset int
+	line   *gpiod.Line
+	bias   gpiod.LineBias
+	// the bounceTime defines the key bounce time (ms)
+	// the value 0 ignores key bouncing, otherwise the kernel debounces the line
+	bounceTime time.Duration
+	// softDebounce is set, if the kernel doesn't support debouncing (uAPI v1, before kernel 5.10),
+	// events within the bounce time after the previous event are ignored
+	softDebounce bool
+	// monotonic is set, if the kernel doesn't support realtime event timestamps (before kernel 5.11),
+	// the edge time is the time of the event handling
+	monotonic bool
+	// lastEvent is the kernel timestamp of the last accepted event, it's used by the software debouncing
+	lastEvent time.Duration
+	// edgeTime is the kernel timestamp of the last detected edge
+	edgeTime time.Time
+	// lastEdge is the direction of the last detected edge
+	lastEdge Edge
+	handler  func(Pin)
+}
+
+type GpiodGPIO struct {
+	// chip is the default chip of NewPin
+	chip  string
+	chips map[string]*gpiod.Chip
+	pins  map[string]*GpiodPin
+}
+
+// OpenGpiod opens the gpio character device /dev/<chip>, e.g. gpiochip0, as default chip.
+// Further chips are opened by NewChipPin.
+func OpenGpiod(chip string) (*GpiodGPIO, error) {
+	c := &GpiodGPIO{chip: chip, chips: map[string]*gpiod.Chip{}, pins: map[string]*GpiodPin{}}
+	if _, err := c.openChip(chip); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close releases all requested lines and closes the gpio character devices.
+func (c *GpiodGPIO) Close() (err error) {
+	for _, p := range c.pins {
+		p.Unwatch()
+	}
+	for _, chip := range c.chips {
+		if e := chip.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// NewPin creates a new pin object of the default chip.
+// The pin number provided is the line offset of the chip, on the raspberry pi it's the BCM GPIO number.
+func (c *GpiodGPIO) NewPin(p int) (Pin, error) {
+	return c.NewChipPin(c.chip, p)
+}
+
+// NewChipPin creates a new pin object of the chip, the chip is opened on first use.
+func (c *GpiodGPIO) NewChipPin(chip string, p int) (Pin, error) {
+	ch, err := c.openChip(chip)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%v/%v", chip, p)
+	if _, ok := c.pins[key]; ok {
+		return nil, fmt.Errorf("pin %v of %v already used", p, chip)
+	}
+
+	if p < 0 || p >= ch.Lines() {
+		return nil, fmt.Errorf("pin %v isn't available on %v", p, ch.Name)
+	}
+
+	l := GpiodPin{chip: ch, offset: p, bias: gpiod.WithBiasAsIs}
+	c.pins[key] = &l
+	return c.pins[key], nil
+}
+
+// openChip returns the opened chip, it's opened on first use.
+func (c *GpiodGPIO) openChip(name string) (*gpiod.Chip, error) {
+	if ch, ok := c.chips[name]; ok {
+		return ch, nil
+	}
+
+	ch, err := gpiod.NewChip(name, gpiod.WithConsumer(consumer))
+	if err != nil {
+		return nil, err
+	}
+	c.chips[name] = ch
+	return ch, nil
+}
+
+// Watch the pin for changes to level.
+// The line is requested with edge detection and the handler is called for each edge event of the kernel.
+// The edge determines which edge to watch.
+// There can only be one watcher on the pin at a time.
+// Older kernels don't support realtime event timestamps (before 5.11) or debouncing (before 5.10),
+// the line is requested without these options and the driver replaces them.
+func (p *GpiodPin) Watch(edge Edge, handler func(Pin)) error {
+	p.Unwatch()
+
+	p.Lock()
+	defer p.Unlock()
+
+	opts := []gpiod.LineReqOption{
+		gpiod.AsInput,
+		p.bias,
+		gpiod.WithEventHandler(p.eventHandler),
+	}
+
+	switch edge {
+	case EdgeRising:
+		opts = append(opts, gpiod.WithRisingEdge)
+	case EdgeFalling:
+		opts = append(opts, gpiod.WithFallingEdge)
+	case EdgeBoth:
+		opts = append(opts, gpiod.WithBothEdges)
+	default:
+		opts = append(opts, gpiod.WithoutEdges)
+	}
+
+	p.handler = handler
+	p.lastEvent = 0
+
+	// the options are dropped step by step, until the kernel accepts the request
+	debounce := []gpiod.LineReqOption{}
+	if p.bounceTime > 0 {
+		debounce = append(debounce, gpiod.WithDebounce(p.bounceTime))
+	}
+	steps := []struct {
+		opts                    []gpiod.LineReqOption
+		monotonic, softDebounce bool
+	}{
+		{opts: append(append([]gpiod.LineReqOption{gpiod.WithRealtimeEventClock}, debounce...), opts...)},
+		{opts: append(debounce, opts...), monotonic: true},
+		{opts: opts, monotonic: true, softDebounce: p.bounceTime > 0},
+	}
+
+	var err error
+	for i, s := range steps {
+		var l *gpiod.Line
+		if l, err = p.chip.RequestLine(p.offset, s.opts...); err != nil {
+			continue
+		}
+
+		if i > 0 {
+			debug.WarningLog.Printf("pin %v: kernel doesn't support realtime event timestamps (monotonic: %v) or debouncing (software debouncing: %v)",
+				p.offset, s.monotonic, s.softDebounce)
+		}
+		p.line, p.monotonic, p.softDebounce = l, s.monotonic, s.softDebounce
+		return nil
+	}
+	return err
+}
+
+// Unwatch removes any watch from the pin and releases the line.
+// The line is closed without the lock, closing waits for the running event handler, which needs the lock.
+func (p *GpiodPin) Unwatch() {
+	p.Lock()
+	l := p.line
+	p.line = nil
+	p.Unlock()
+
+	if l != nil {
+		_ = l.Close()
+	}
+}
+
+// SetBounceTime defines the debounce period of the line, it's applied by the next call of Watch
+func (p *GpiodPin) SetBounceTime(t time.Duration) {
+	p.bounceTime = t
+}
+
+// Input sets pin as Input.
+// The line is always requested as input, so there is nothing to do.
+func (p *GpiodPin) Input() {
+}
+
+// PullUp sets the pull state of the pin to PullUp
+func (p *GpiodPin) PullUp() {
+	p.setBias(gpiod.WithPullUp)
+}
+
+// PullDown sets the pull state of the pin to PullDown
+func (p *GpiodPin) PullDown() {
+	p.setBias(gpiod.WithPullDown)
+}
+
+// PullNone disables the pull resistor of the pin
+func (p *GpiodPin) PullNone() {
+	p.setBias(gpiod.WithBiasDisabled)
+}
+
+// Pin returns the pin number that this Pin represents.
+func (p *GpiodPin) Pin() int {
+	return p.offset
+}
+
+// Read pin state (high/low)
+// If the line isn't watched, it's requested for the time of reading.
+func (p *GpiodPin) Read() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	l := p.line
+	if l == nil {
+		var err error
+		if l, err = p.chip.RequestLine(p.offset, gpiod.AsInput, p.bias); err != nil {
+			return false
+		}
+		defer func() { _ = l.Close() }()
+	}
+
+	v, err := l.Value()
+	return err == nil && v == 1
+}
+
+// EmuEdge emulate a statechange of given pin on Windows systems
+// not supported for linux
+func (p *GpiodPin) EmuEdge(edge Edge) {
+	return
+}
+
+// EdgeTime returns the kernel timestamp of the last detected edge.
+func (p *GpiodPin) EdgeTime() time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.edgeTime
+}
+
+// LastEdge returns the direction of the last detected edge.
+func (p *GpiodPin) LastEdge() Edge {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.lastEdge
+}
+
+// setBias stores the bias of the line and reconfigure the line if it's already requested.
+func (p *GpiodPin) setBias(bias gpiod.LineBias) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.bias = bias
+	if p.line != nil {
+		_ = p.line.Reconfigure(bias)
+	}
+}
+
+// eventHandler is called serially by the line watcher for each edge event.
+func (p *GpiodPin) eventHandler(evt gpiod.LineEvent) {
+	p.Lock()
+	if p.softDebounce && p.lastEvent != 0 && evt.Timestamp-p.lastEvent < p.bounceTime {
+		p.Unlock()
+		return
+	}
+	p.lastEvent = evt.Timestamp
+
+	// the monotonic timestamp is the time since boot, it can't be converted to the wall time
+	p.edgeTime = time.Unix(0, int64(evt.Timestamp))
+	if p.monotonic {
+		p.edgeTime = time.Now()
+	}
+	p.lastEdge = EdgeFalling
+	if evt.Type == gpiod.LineEventRisingEdge {
+		p.lastEdge = EdgeRising
+	}
+	h := p.handler
+	p.Unlock()
+
+	if h != nil {
+		h(p)
+	}
+}
@@ -0,0 +1,10 @@
+//+build !linux
+
+package raspberry
+
+import "errors"
+
+// OpenGpiod opens the gpio character device, it's only supported on linux systems
+func OpenGpiod(chip string) (GPIO, error) {
+	return nil, errors.New("gpiod driver is only supported on linux")
+}
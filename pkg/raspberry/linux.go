@@ -91,6 +91,11 @@ func (p *RpiPin) PullDown() {
 	p.gpioPin.PullDown()
 }
 
+// PullNone disables the pull resistor of the pin
+func (p *RpiPin) PullNone() {
+	p.gpioPin.PullNone()
+}
+
 // Pin returns the pin number that this Pin represents.
 func (p *RpiPin) Pin() int {
 	return p.gpioPin.Pin()
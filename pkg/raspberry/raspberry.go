@@ -1,5 +1,4 @@
 // https://github.com/mrmorphic/hwio
-// TODO: Use package gpiod https://github.com/warthog618/gpiod
 
 // Package raspberry provides functionality for reading and writing to gpio pins
 package raspberry
@@ -37,7 +36,39 @@ type Pin interface {
 	Input()
 	PullUp()
 	PullDown()
+	PullNone()
 	Pin() int
 	Read() bool
 	EmuEdge(Edge)
 }
+
+// Starter is implemented by gpio drivers which generate the edges by themselves,
+// e.g. the replay of a recording. Start is called after all pins are watched.
+type Starter interface {
+	Start()
+}
+
+// Clock is implemented by gpio drivers with their own time base, e.g. the replay of a recording.
+// The timestamps of the pulses and all time dependent calculations use the clock instead of the system time.
+type Clock interface {
+	Now() time.Time
+}
+
+// ChipGPIO is implemented by gpio drivers with several gpio chips, e.g. the gpio character device.
+// NewPin creates the pin of the default chip.
+type ChipGPIO interface {
+	NewChipPin(chip string, p int) (Pin, error)
+}
+
+// EdgeReader is implemented by pins which know the direction of the last detected edge,
+// e.g. the event type of the gpio character device. Reading the level after the edge can miss a short spike,
+// the level can already be changed back by the next edge.
+type EdgeReader interface {
+	LastEdge() Edge
+}
+
+// EdgeTimer is implemented by pins which know the exact time of the last detected edge,
+// e.g. the kernel timestamp of the gpio character device.
+type EdgeTimer interface {
+	EdgeTime() time.Time
+}
@@ -0,0 +1,240 @@
+package raspberry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"s0counter/pkg/pulselog"
+	"sync"
+	"time"
+)
+
+type ReplayPin struct {
+	sync.Mutex
+	pin      int
+	edge     Edge
+	handler  func(Pin)
+	edgeTime time.Time
+	lastEdge Edge
+}
+
+type ReplayGPIO struct {
+	sync.Mutex
+	recording *pulselog.Reader
+	// speed is the replay speed, e.g. 1 >> real time, 10 >> ten times faster
+	speed float64
+	// pins are the pins by chip and gpio number, e.g. "/17" of the default chip, "gpiochip4/17"
+	pins  map[string]*ReplayPin
+	stop  chan struct{}
+	start sync.Once
+	close sync.Once
+	// next is the first pulse of the recording, nil if the recording is empty
+	next *pulselog.Record
+	// first is the recorded time of the first pulse
+	first time.Time
+	// started is the system time of the start of the replay
+	started time.Time
+}
+
+// OpenReplay opens a pulse recording, the recorded pulses are replayed on the pins after calling Start.
+func OpenReplay(file string, speed float64) (*ReplayGPIO, error) {
+	r, err := pulselog.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ReplayGPIO{
+		recording: r,
+		speed:     speed,
+		pins:      map[string]*ReplayPin{},
+		stop:      make(chan struct{}),
+	}
+
+	// the first pulse defines the start of the replay clock
+	rec, err := r.Read()
+	switch {
+	case err == nil:
+		c.next, c.first = &rec, rec.TimeStamp
+	case err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF):
+		_ = r.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close stops the replay and closes the recording, further calls do nothing.
+func (c *ReplayGPIO) Close() (err error) {
+	c.close.Do(func() {
+		close(c.stop)
+		err = c.recording.Close()
+	})
+	return err
+}
+
+// NewPin creates a new pin object of the default chip.
+func (c *ReplayGPIO) NewPin(p int) (Pin, error) {
+	return c.NewChipPin("", p)
+}
+
+// NewChipPin creates a new pin object of the chip, the pulses are replayed on the pin of the recorded chip.
+func (c *ReplayGPIO) NewChipPin(chip string, p int) (Pin, error) {
+	key := pinKey(chip, p)
+	if _, ok := c.pins[key]; ok {
+		return nil, fmt.Errorf("pin %v of chip %q already used", p, chip)
+	}
+
+	l := ReplayPin{pin: p}
+	c.pins[key] = &l
+	return &l, nil
+}
+
+// pinKey returns the key of the pin of the chip.
+func pinKey(chip string, p int) string {
+	return fmt.Sprintf("%v/%v", chip, p)
+}
+
+// Start starts the replay of the recording.
+// The pins must be created before, pulses of unknown pins are skipped.
+func (c *ReplayGPIO) Start() {
+	c.start.Do(func() {
+		c.Lock()
+		c.started = time.Now()
+		c.Unlock()
+
+		go c.run()
+	})
+}
+
+// Now returns the time of the replay clock. The clock starts at the recorded time of the first pulse
+// and runs with the replay speed, so the pulses are replayed with their recorded timestamps.
+// Before the start of the replay, the clock stands at the first pulse, without pulses it's the system time.
+func (c *ReplayGPIO) Now() time.Time {
+	if c.next == nil {
+		return time.Now()
+	}
+
+	c.Lock()
+	started := c.started
+	c.Unlock()
+
+	if started.IsZero() {
+		return c.first
+	}
+	return c.first.Add(time.Duration(float64(time.Since(started)) * c.speed))
+}
+
+// run replays the pulses of the recording with their recorded timestamps.
+// The time between two pulses is divided by the speed.
+func (c *ReplayGPIO) run() {
+	rec := c.next
+	for rec != nil {
+		due := c.started.Add(time.Duration(float64(rec.TimeStamp.Sub(c.first)) / c.speed))
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(time.Until(due)):
+		}
+
+		if p, ok := c.pins[pinKey(rec.Chip, rec.Pin)]; ok {
+			p.pulse(rec.TimeStamp)
+		}
+
+		r, err := c.recording.Read()
+		if err != nil {
+			return
+		}
+		rec = &r
+	}
+}
+
+// Watch the pin for replayed pulses.
+// There can only be one watcher on the pin at a time.
+func (p *ReplayPin) Watch(edge Edge, handler func(Pin)) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.handler = handler
+	p.edge = edge
+	return nil
+}
+
+// Unwatch removes any watch from the pin.
+func (p *ReplayPin) Unwatch() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.handler = nil
+}
+
+// SetBounceTime isn't supported for replayed pins, the recording contains debounced pulses
+func (p *ReplayPin) SetBounceTime(t time.Duration) {
+	return
+}
+
+// Input sets pin as Input.
+func (p *ReplayPin) Input() {
+}
+
+// PullUp sets the pull state of the pin to PullUp
+func (p *ReplayPin) PullUp() {
+}
+
+// PullDown sets the pull state of the pin to PullDown
+func (p *ReplayPin) PullDown() {
+}
+
+// PullNone disables the pull resistor of the pin
+func (p *ReplayPin) PullNone() {
+}
+
+// Pin returns the pin number that this Pin represents.
+func (p *ReplayPin) Pin() int {
+	return p.pin
+}
+
+// Read returns the level of the pin after the last replayed edge, it's low before the first edge.
+func (p *ReplayPin) Read() bool {
+	return p.LastEdge() == EdgeRising
+}
+
+// EmuEdge emulate a statechange of given pin on Windows systems
+// not supported for replayed pins
+func (p *ReplayPin) EmuEdge(edge Edge) {
+	return
+}
+
+// EdgeTime returns the time of the last replayed pulse.
+func (p *ReplayPin) EdgeTime() time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.edgeTime
+}
+
+// LastEdge returns the direction of the last replayed edge.
+// The recording contains the counted pulses, so the replayed edge is the watched edge.
+// If both edges are watched, the direction isn't recorded and a falling edge is replayed.
+func (p *ReplayPin) LastEdge() Edge {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.lastEdge
+}
+
+// pulse calls the handler of a watched pin for a replayed pulse.
+func (p *ReplayPin) pulse(t time.Time) {
+	p.Lock()
+	h := p.handler
+	if h == nil || p.edge == EdgeNone {
+		p.Unlock()
+		return
+	}
+	p.edgeTime = t
+	p.lastEdge = p.edge
+	if p.edge == EdgeBoth {
+		p.lastEdge = EdgeFalling
+	}
+	p.Unlock()
+
+	h(p)
+}
@@ -0,0 +1,221 @@
+package raspberry
+
+import (
+	"path/filepath"
+	"s0counter/pkg/pulselog"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayClock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	first := time.Date(2026, 3, 10, 23, 59, 0, 0, time.UTC)
+
+	w, err := pulselog.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pulses every 10 seconds over midnight
+	var want []time.Time
+	for i := 0; i < 13; i++ {
+		ts := first.Add(time.Duration(i) * 10 * time.Second)
+		want = append(want, ts)
+		if err = w.Write(pulselog.Record{Meter: "grid", Pin: 17, TimeStamp: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 120 seconds of the recording are replayed in 0.12 seconds
+	c, err := OpenReplay(file, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if got := c.Now(); !got.Equal(first) {
+		t.Errorf("clock before the start %v, want %v", got, first)
+	}
+
+	p, err := c.NewPin(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var got, clock []time.Time
+	done := make(chan struct{})
+	_ = p.Watch(EdgeFalling, func(p Pin) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		got = append(got, p.(EdgeTimer).EdgeTime())
+		clock = append(clock, c.Now())
+		if len(got) == len(want) {
+			close(done)
+		}
+	})
+
+	c.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't finish")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range want {
+		// the pulses keep their recorded timestamps
+		if !got[i].Equal(want[i]) {
+			t.Errorf("pulse %v at %v, want %v", i, got[i], want[i])
+		}
+		// the clock runs with the replay speed, it's at the pulse, when the pulse is replayed
+		if d := clock[i].Sub(want[i]); d < 0 || d > time.Minute {
+			t.Errorf("clock at pulse %v is %v, want %v", i, clock[i], want[i])
+		}
+	}
+}
+
+func TestReplayChips(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	first := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	w, err := pulselog.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, chip := range []string{"", "gpiochip4", "gpiochip4", ""} {
+		if err = w.Write(pulselog.Record{Meter: "m" + chip, Chip: chip, Pin: 17, TimeStamp: first.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenReplay(file, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var mu sync.Mutex
+	pulses := map[string]int{}
+	done := make(chan struct{})
+	n := 0
+	for _, chip := range []string{"", "gpiochip4"} {
+		chip := chip
+		p, err := c.NewChipPin(chip, 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = p.Watch(EdgeFalling, func(Pin) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			pulses[chip]++
+			if n++; n == 4 {
+				close(done)
+			}
+		})
+	}
+	if _, err = c.NewPin(17); err == nil {
+		t.Error("pin 17 of the default chip is opened twice")
+	}
+
+	c.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't finish")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if pulses[""] != 2 || pulses["gpiochip4"] != 2 {
+		t.Errorf("pulses %v, want 2 per chip", pulses)
+	}
+}
+
+func TestReplayEdges(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pulses.rec")
+	first := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	w, err := pulselog.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range []int{17, 18, 27} {
+		if err = w.Write(pulselog.Record{Meter: "grid", Pin: p, TimeStamp: first.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenReplay(file, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the level of the pin is the level after the watched edge
+	want := map[int]struct {
+		edge  Edge
+		level bool
+	}{
+		17: {EdgeFalling, false},
+		18: {EdgeRising, true},
+		27: {EdgeBoth, false},
+	}
+	var mu sync.Mutex
+	got := map[int]bool{}
+	done := make(chan struct{})
+	for pin, tc := range want {
+		p, err := c.NewPin(pin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Read() {
+			t.Errorf("pin %v is high before the first edge", pin)
+		}
+		_ = p.Watch(tc.edge, func(p Pin) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			got[p.Pin()] = p.Read()
+			if e := p.(EdgeReader).LastEdge(); (e == EdgeRising) != want[p.Pin()].level {
+				t.Errorf("pin %v: last edge %v, want level %v", p.Pin(), e, want[p.Pin()].level)
+			}
+			if len(got) == len(want) {
+				close(done)
+			}
+		})
+	}
+
+	c.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't finish")
+	}
+
+	mu.Lock()
+	for pin, tc := range want {
+		if got[pin] != tc.level {
+			t.Errorf("pin %v watched on %v edge: level %v, want %v", pin, tc.edge, got[pin], tc.level)
+		}
+	}
+	mu.Unlock()
+
+	// the replay can be closed more than once
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,313 @@
+package raspberry
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// supported profiles of the pulse simulation
+const (
+	// SimConstant generates pulses with a constant rate.
+	SimConstant = "constant"
+	// SimPoisson generates pulses with exponentially distributed intervals (poisson process) around the mean rate.
+	SimPoisson = "poisson"
+	// SimSequence generates pulses with the intervals of a scripted sequence, the sequence is repeated endless.
+	SimSequence = "sequence"
+)
+
+// simBounceInterval is the time between two contact bounces of a simulated edge
+const simBounceInterval = time.Millisecond
+
+// SimProfile defines the pulses generated on a simulated pin.
+type SimProfile struct {
+	Profile    string          // SimConstant, SimPoisson or SimSequence
+	Rate       float64         // mean pulses per hour (SimConstant, SimPoisson)
+	Sequence   []time.Duration // intervals between the start of two pulses (SimSequence)
+	PulseWidth time.Duration   // duration of the active level of a pulse
+	Bounces    int             // number of contact bounces on each edge
+}
+
+type SimPin struct {
+	sync.Mutex
+	pin     int
+	profile SimProfile
+	edge    Edge
+	handler func(Pin)
+	// idle is the level of the pin without a pulse, it depends on the pull resistor
+	idle  bool
+	level bool
+	// the bounceTime defines the key bounce time (ms)
+	// the value 0 ignores key bouncing
+	bounceTime time.Duration
+	// while bouncing is set, new signals are ignored (suppress key bouncing)
+	bouncing bool
+	shadow   bool
+	edgeTime time.Time
+	lastEdge Edge
+	stop     chan struct{}
+}
+
+type SimGPIO struct {
+	profiles map[int]SimProfile
+	pins     map[int]*SimPin
+}
+
+// OpenSim opens a simulated gpio, the pulses of each pin are generated by the profile of the pin number.
+func OpenSim(profiles map[int]SimProfile) (*SimGPIO, error) {
+	return &SimGPIO{profiles: profiles, pins: map[int]*SimPin{}}, nil
+}
+
+// Close stops the pulse generation of all pins
+func (c *SimGPIO) Close() error {
+	for _, p := range c.pins {
+		p.Unwatch()
+	}
+	return nil
+}
+
+// NewPin creates a new pin object.
+func (c *SimGPIO) NewPin(p int) (Pin, error) {
+	if _, ok := c.pins[p]; ok {
+		return nil, fmt.Errorf("pin %v already used", p)
+	}
+
+	profile, ok := c.profiles[p]
+	if !ok {
+		return nil, fmt.Errorf("no simulation profile for pin %v", p)
+	}
+
+	l := SimPin{pin: p, profile: profile, idle: true, level: true, shadow: true}
+	c.pins[p] = &l
+	return c.pins[p], nil
+}
+
+// Watch the pin for changes to level and starts the pulse generation.
+// The handler is called after bounce timeout and the state is still changed from shadow
+// The edge determines which edge to watch.
+// There can only be one watcher on the pin at a time.
+func (p *SimPin) Watch(edge Edge, handler func(Pin)) error {
+	p.Unwatch()
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.handler = handler
+	p.edge = edge
+	p.stop = make(chan struct{})
+	go p.run(p.stop)
+	return nil
+}
+
+// Unwatch stops the pulse generation and removes any watch from the pin.
+func (p *SimPin) Unwatch() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// SetBounceTime defines Timer which has to expired to check if the pin has still the correct level
+func (p *SimPin) SetBounceTime(t time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.bounceTime = t
+}
+
+// Input sets pin as Input.
+func (p *SimPin) Input() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.shadow = p.level
+}
+
+// PullUp sets the pull state of the pin to PullUp, the idle level is high and a pulse pulls the level to low
+func (p *SimPin) PullUp() {
+	p.setIdle(true)
+}
+
+// PullDown sets the pull state of the pin to PullDown, the idle level is low and a pulse drives the level to high
+func (p *SimPin) PullDown() {
+	p.setIdle(false)
+}
+
+// PullNone disables the pull resistor of the pin, the simulated level keeps the current idle level
+func (p *SimPin) PullNone() {
+}
+
+// Pin returns the pin number that this Pin represents.
+func (p *SimPin) Pin() int {
+	return p.pin
+}
+
+// Read pin state (high/low)
+func (p *SimPin) Read() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.level
+}
+
+// EmuEdge emulate a statechange of given pin on Windows systems
+// not supported for simulated pins, the pulses are generated by the profile
+func (p *SimPin) EmuEdge(edge Edge) {
+	return
+}
+
+// EdgeTime returns the time of the last detected edge.
+func (p *SimPin) EdgeTime() time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.edgeTime
+}
+
+// LastEdge returns the direction of the last detected edge.
+func (p *SimPin) LastEdge() Edge {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.lastEdge
+}
+
+func (p *SimPin) setIdle(level bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.idle = level
+	p.level = level
+	p.shadow = level
+}
+
+// run generates the pulses of the profile until the stop channel is closed.
+func (p *SimPin) run(stop <-chan struct{}) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(p.pin)))
+
+	for i := 0; ; i++ {
+		// the interval is the time between the start of two pulses
+		wait := p.interval(rnd, i) - p.profile.PulseWidth
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		p.Lock()
+		active := !p.idle
+		p.Unlock()
+
+		p.setLevel(active)
+		select {
+		case <-stop:
+			return
+		case <-time.After(p.profile.PulseWidth):
+		}
+		p.setLevel(!active)
+	}
+}
+
+// interval returns the time between the start of the pulses i and i+1.
+func (p *SimPin) interval(rnd *rand.Rand, i int) time.Duration {
+	switch p.profile.Profile {
+	case SimPoisson:
+		return time.Duration(rnd.ExpFloat64() / p.profile.Rate * float64(time.Hour))
+	case SimSequence:
+		return p.profile.Sequence[i%len(p.profile.Sequence)]
+	default:
+		return time.Duration(float64(time.Hour) / p.profile.Rate)
+	}
+}
+
+// setLevel changes the level of the pin, including the configured contact bounces.
+func (p *SimPin) setLevel(level bool) {
+	for b := 0; b < p.profile.Bounces; b++ {
+		p.toggle(level)
+		time.Sleep(simBounceInterval)
+		p.toggle(!level)
+		time.Sleep(simBounceInterval)
+	}
+	p.toggle(level)
+}
+
+// toggle sets the level of the pin and detects the edge.
+func (p *SimPin) toggle(level bool) {
+	p.Lock()
+	if p.level == level {
+		p.Unlock()
+		return
+	}
+
+	p.level = level
+	edge := EdgeFalling
+	if level {
+		edge = EdgeRising
+	}
+
+	if p.edge != EdgeBoth && p.edge != edge {
+		p.Unlock()
+		return
+	}
+
+	// if debounce is inactive, call handler function and returns
+	if p.bounceTime == 0 {
+		p.shadow = level
+		p.edgeTime = time.Now()
+		p.lastEdge = edge
+		p.Unlock()
+		p.handler(p)
+		return
+	}
+
+	// if bounce Timer is still running, ignore the signal
+	if p.bouncing {
+		p.Unlock()
+		return
+	}
+
+	p.bouncing = true
+	t := time.Now()
+	p.Unlock()
+
+	time.AfterFunc(p.bounceTime, func() { p.debounce(t) })
+}
+
+// debounce ensures that state change lasts for at least the BounceTime without interruption and only then the handler is called
+func (p *SimPin) debounce(t time.Time) {
+	p.Lock()
+	p.bouncing = false
+
+	// the correct level depends on the edge configuration
+	var ok bool
+	switch p.edge {
+	case EdgeBoth:
+		ok = p.level != p.shadow
+	case EdgeFalling:
+		ok = !p.level
+	case EdgeRising:
+		ok = p.level
+	}
+
+	if !ok {
+		p.Unlock()
+		return
+	}
+
+	p.shadow = p.level
+	p.edgeTime = t
+	p.lastEdge = EdgeFalling
+	if p.level {
+		p.lastEdge = EdgeRising
+	}
+	p.Unlock()
+	p.handler(p)
+}
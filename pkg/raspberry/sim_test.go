@@ -0,0 +1,144 @@
+package raspberry
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSimInterval(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	constant := SimPin{profile: SimProfile{Profile: SimConstant, Rate: 3600}}
+	for i := 0; i < 3; i++ {
+		if got := constant.interval(rnd, i); got != time.Second {
+			t.Errorf("constant interval %v: got %v, want 1s", i, got)
+		}
+	}
+
+	sequence := SimPin{profile: SimProfile{Profile: SimSequence, Sequence: []time.Duration{time.Second, 3 * time.Second}}}
+	for i, want := range []time.Duration{time.Second, 3 * time.Second, time.Second, 3 * time.Second} {
+		if got := sequence.interval(rnd, i); got != want {
+			t.Errorf("sequence interval %v: got %v, want %v", i, got, want)
+		}
+	}
+
+	// the mean of the poisson intervals is the interval of the rate
+	poisson := SimPin{profile: SimProfile{Profile: SimPoisson, Rate: 3600}}
+	var sum time.Duration
+	n := 10000
+	for i := 0; i < n; i++ {
+		d := poisson.interval(rnd, i)
+		if d < 0 {
+			t.Fatalf("poisson interval %v: got %v, want a positive interval", i, d)
+		}
+		sum += d
+	}
+	if mean := sum / time.Duration(n); math.Abs(mean.Seconds()-1) > 0.05 {
+		t.Errorf("poisson mean interval: got %v, want 1s", mean)
+	}
+}
+
+func TestSimBounces(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		bounceTime time.Duration
+		want       int
+	}{
+		// each bounce of the active and the release edge is a falling edge
+		{"without debounce", 0, 7},
+		{"debounced", 10 * time.Millisecond, 1},
+	} {
+		c, _ := OpenSim(map[int]SimProfile{17: {Profile: SimConstant, Rate: 1, Bounces: 3}})
+		pin, err := c.NewPin(17)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := pin.(*SimPin)
+		p.PullUp()
+		p.SetBounceTime(tc.bounceTime)
+
+		// the handler is set without Watch, so the pulse is generated by the test instead of the profile
+		var mu sync.Mutex
+		pulses := 0
+		p.edge = EdgeFalling
+		p.handler = func(Pin) {
+			mu.Lock()
+			defer mu.Unlock()
+			pulses++
+		}
+
+		// the pulse is longer than the bounce time
+		p.setLevel(false)
+		time.Sleep(2*tc.bounceTime + simBounceInterval)
+		p.setLevel(true)
+		time.Sleep(2*tc.bounceTime + simBounceInterval)
+
+		mu.Lock()
+		got := pulses
+		mu.Unlock()
+		if got != tc.want {
+			t.Errorf("%v: got %v pulses, want %v", tc.name, got, tc.want)
+		}
+		if !p.Read() {
+			t.Errorf("%v: the pin isn't released to the idle level", tc.name)
+		}
+		if e := p.LastEdge(); e != EdgeFalling {
+			t.Errorf("%v: got last edge %v, want %v", tc.name, e, EdgeFalling)
+		}
+	}
+}
+
+func TestSimWatch(t *testing.T) {
+	// a pulse every 20ms
+	c, _ := OpenSim(map[int]SimProfile{17: {Profile: SimConstant, Rate: 180000, PulseWidth: 5 * time.Millisecond}})
+	p, err := c.NewPin(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.NewPin(17); err == nil {
+		t.Error("pin 17 is opened twice")
+	}
+	if _, err = c.NewPin(18); err == nil {
+		t.Error("pin 18 without profile is opened")
+	}
+	p.PullUp()
+
+	var mu sync.Mutex
+	var edges []time.Time
+	done := make(chan struct{})
+	_ = p.Watch(EdgeFalling, func(p Pin) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if edges = append(edges, p.(EdgeTimer).EdgeTime()); len(edges) == 5 {
+			close(done)
+		}
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pulses generated")
+	}
+
+	// no pulses are generated after closing the gpio
+	_ = c.Close()
+	mu.Lock()
+	n := len(edges)
+	for i := 1; i < 5; i++ {
+		if d := edges[i].Sub(edges[i-1]); d < 15*time.Millisecond {
+			t.Errorf("interval %v: got %v, want 20ms", i, d)
+		}
+	}
+	mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(edges) > n+1 {
+		t.Errorf("got %v pulses after closing the gpio", len(edges)-n)
+	}
+}
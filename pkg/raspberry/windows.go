@@ -69,6 +69,10 @@ func (p *WinPin) PullUp() {
 func (p *WinPin) PullDown() {
 }
 
+// PullNone disables the pull resistor of the pin
+func (p *WinPin) PullNone() {
+}
+
 // Pin returns the pin number that this Pin represents.
 func (p *WinPin) Pin() int {
 	return p.gpioPin
@@ -0,0 +1,339 @@
+// Package sparkplug provides the topics and the payload encoding of the Sparkplug B specification.
+//
+// Only the parts of the protobuf schema used by an edge node are implemented: the payload with timestamp,
+// metrics and sequence number, metrics with scalar values and string properties (e.g. engUnit).
+package sparkplug
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Namespace is the topic namespace of Sparkplug B
+const Namespace = "spBv1.0"
+
+// message types of the topics
+const (
+	NBirth = "NBIRTH"
+	NDeath = "NDEATH"
+	NData  = "NDATA"
+	NCmd   = "NCMD"
+	DBirth = "DBIRTH"
+	DDeath = "DDEATH"
+	DData  = "DDATA"
+)
+
+// names of the node control metrics
+const (
+	MetricBdSeq   = "bdSeq"
+	MetricRebirth = "Node Control/Rebirth"
+)
+
+// DataType is the data type of a metric.
+type DataType uint32
+
+// supported data types of the metric values
+const (
+	Int64    DataType = 4
+	UInt64   DataType = 8
+	Double   DataType = 10
+	Boolean  DataType = 11
+	String   DataType = 12
+	DateTime DataType = 13
+)
+
+// Metric is a value of a node or device.
+type Metric struct {
+	Name       string
+	TimeStamp  time.Time
+	DataType   DataType
+	Value      interface{}       // int64, uint64, float64, bool, string or time.Time according to the data type
+	Properties map[string]string // string properties, e.g. engUnit
+}
+
+// Payload is the payload of a Sparkplug B message.
+type Payload struct {
+	TimeStamp time.Time
+	Metrics   []Metric
+	Seq       *uint64 // sequence number, it's nil for NDEATH
+}
+
+// field numbers of the protobuf schema
+const (
+	payloadTimestamp = 1
+	payloadMetrics   = 2
+	payloadSeq       = 3
+
+	metricName       = 1
+	metricTimestamp  = 3
+	metricDatatype   = 4
+	metricProperties = 9
+	metricLong       = 11
+	metricDouble     = 13
+	metricBoolean    = 14
+	metricString     = 15
+
+	propertyKeys   = 1
+	propertyValues = 2
+
+	propertyValueType   = 1
+	propertyValueString = 8
+)
+
+// ErrInvalidPayload is returned by Unmarshal, if the payload isn't a valid protobuf message.
+var ErrInvalidPayload = errors.New("invalid sparkplug payload")
+
+// Topic returns the topic of a message type, the device is empty for node messages.
+func Topic(group, messageType, node, device string) string {
+	t := Namespace + "/" + group + "/" + messageType + "/" + node
+	if device != "" {
+		t += "/" + device
+	}
+	return t
+}
+
+// Seq returns a pointer to the sequence number, e.g. Payload{Seq: Seq(0)}
+func Seq(n uint64) *uint64 {
+	return &n
+}
+
+// Marshal encodes the payload as protobuf message.
+func (p Payload) Marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, millis(p.TimeStamp))
+
+	for _, m := range p.Metrics {
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.marshal())
+	}
+
+	if p.Seq != nil {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *p.Seq)
+	}
+	return b
+}
+
+// marshal encodes the metric as protobuf message.
+func (m Metric) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, metricName, protowire.BytesType)
+	b = protowire.AppendString(b, m.Name)
+
+	if !m.TimeStamp.IsZero() {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, millis(m.TimeStamp))
+	}
+
+	b = protowire.AppendTag(b, metricDatatype, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.DataType))
+
+	if len(m.Properties) > 0 {
+		b = protowire.AppendTag(b, metricProperties, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalProperties(m.Properties))
+	}
+
+	switch v := m.Value.(type) {
+	case int64:
+		b = protowire.AppendTag(b, metricLong, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case uint64:
+		b = protowire.AppendTag(b, metricLong, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case time.Time:
+		b = protowire.AppendTag(b, metricLong, protowire.VarintType)
+		b = protowire.AppendVarint(b, millis(v))
+	case float64:
+		b = protowire.AppendTag(b, metricDouble, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case bool:
+		b = protowire.AppendTag(b, metricBoolean, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case string:
+		b = protowire.AppendTag(b, metricString, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+// marshalProperties encodes the properties as protobuf PropertySet, the keys are sorted.
+func marshalProperties(properties map[string]string) []byte {
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b []byte
+	for _, k := range keys {
+		b = protowire.AppendTag(b, propertyKeys, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+	}
+	for _, k := range keys {
+		var v []byte
+		v = protowire.AppendTag(v, propertyValueType, protowire.VarintType)
+		v = protowire.AppendVarint(v, uint64(String))
+		v = protowire.AppendTag(v, propertyValueString, protowire.BytesType)
+		v = protowire.AppendString(v, properties[k])
+
+		b = protowire.AppendTag(b, propertyValues, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	return b
+}
+
+// Unmarshal decodes a protobuf message, e.g. a NCMD.
+// The metrics are decoded with their scalar values and string properties, other fields are skipped.
+func Unmarshal(b []byte) (p Payload, err error) {
+	err = fields(b, func(num protowire.Number, typ protowire.Type, v uint64, bytes []byte) error {
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			p.TimeStamp = fromMillis(v)
+		case num == payloadSeq && typ == protowire.VarintType:
+			p.Seq = Seq(v)
+		case num == payloadMetrics && typ == protowire.BytesType:
+			m, err := unmarshalMetric(bytes)
+			if err != nil {
+				return err
+			}
+			p.Metrics = append(p.Metrics, m)
+		}
+		return nil
+	})
+	return
+}
+
+// unmarshalMetric decodes a metric, the value of a long field is converted according to the data type.
+func unmarshalMetric(b []byte) (m Metric, err error) {
+	var long *uint64
+	err = fields(b, func(num protowire.Number, typ protowire.Type, v uint64, bytes []byte) error {
+		switch num {
+		case metricName:
+			m.Name = string(bytes)
+		case metricTimestamp:
+			m.TimeStamp = fromMillis(v)
+		case metricDatatype:
+			m.DataType = DataType(v)
+		case metricProperties:
+			p, err := unmarshalProperties(bytes)
+			if err != nil {
+				return err
+			}
+			m.Properties = p
+		case metricLong:
+			long = &v
+		case metricDouble:
+			m.Value = math.Float64frombits(v)
+		case metricBoolean:
+			m.Value = protowire.DecodeBool(v)
+		case metricString:
+			m.Value = string(bytes)
+		}
+		return nil
+	})
+
+	// the data type can follow the value
+	if long != nil {
+		switch m.DataType {
+		case Int64:
+			m.Value = int64(*long)
+		case DateTime:
+			m.Value = fromMillis(*long)
+		default:
+			m.Value = *long
+		}
+	}
+	return
+}
+
+// unmarshalProperties decodes a PropertySet, only string values are decoded, other properties are skipped.
+func unmarshalProperties(b []byte) (map[string]string, error) {
+	var keys []string
+	var values []*string
+	err := fields(b, func(num protowire.Number, typ protowire.Type, _ uint64, bytes []byte) error {
+		switch {
+		case num == propertyKeys && typ == protowire.BytesType:
+			keys = append(keys, string(bytes))
+		case num == propertyValues && typ == protowire.BytesType:
+			var s *string
+			err := fields(bytes, func(num protowire.Number, typ protowire.Type, _ uint64, bytes []byte) error {
+				if num == propertyValueString && typ == protowire.BytesType {
+					v := string(bytes)
+					s = &v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			values = append(values, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(values) {
+		return nil, ErrInvalidPayload
+	}
+
+	properties := map[string]string{}
+	for i, k := range keys {
+		if values[i] != nil {
+			properties[k] = *values[i]
+		}
+	}
+	return properties, nil
+}
+
+// fields calls f for each field of a protobuf message, v contains the value of
+// varint and fixed fields, bytes the value of length delimited fields.
+func fields(b []byte, f func(num protowire.Number, typ protowire.Type, v uint64, bytes []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrInvalidPayload
+		}
+		b = b[n:]
+
+		var v uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return ErrInvalidPayload
+		}
+		b = b[n:]
+
+		if err := f(num, typ, v, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// millis returns the time as milliseconds since epoch (UTC), the time format of Sparkplug B.
+func millis(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func fromMillis(ms uint64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
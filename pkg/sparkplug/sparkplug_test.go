@@ -0,0 +1,266 @@
+package sparkplug
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Date(2026, 3, 10, 10, 15, 30, 123000000, time.UTC)
+	for _, p := range []Payload{
+		// NBIRTH with bdSeq and rebirth metric
+		{TimeStamp: now, Seq: Seq(0), Metrics: []Metric{
+			{Name: MetricBdSeq, DataType: UInt64, Value: uint64(42)},
+			{Name: MetricRebirth, DataType: Boolean, Value: false},
+		}},
+		// DDATA with all supported data types and properties
+		{TimeStamp: now, Seq: Seq(255), Metrics: []Metric{
+			{Name: "Counter", TimeStamp: now, DataType: Double, Value: 1234.567, Properties: map[string]string{"engUnit": "kWh", "description": "net counter"}},
+			{Name: "Gauge", DataType: Double, Value: -3.5, Properties: map[string]string{"engUnit": "kW"}},
+			{Name: "Ticks", DataType: UInt64, Value: uint64(1<<63 + 1)},
+			{Name: "Offset", DataType: Int64, Value: int64(-12345)},
+			{Name: "LastPulse", DataType: DateTime, Value: now},
+			{Name: "Tariff", DataType: String, Value: "night"},
+			{Name: "Online", DataType: Boolean, Value: true},
+		}},
+		// NDEATH has no sequence number
+		{TimeStamp: now, Metrics: []Metric{{Name: MetricBdSeq, DataType: UInt64, Value: uint64(7)}}},
+	} {
+		got, err := Unmarshal(p.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.TimeStamp.Equal(p.TimeStamp) {
+			t.Errorf("timestamp %v, want %v", got.TimeStamp, p.TimeStamp)
+		}
+		if !reflect.DeepEqual(got.Seq, p.Seq) {
+			t.Errorf("seq %v, want %v", got.Seq, p.Seq)
+		}
+		if len(got.Metrics) != len(p.Metrics) {
+			t.Fatalf("%v metrics, want %v", len(got.Metrics), len(p.Metrics))
+		}
+		for i, want := range p.Metrics {
+			m := got.Metrics[i]
+			if m.Name != want.Name || m.DataType != want.DataType || !m.TimeStamp.Equal(want.TimeStamp) || !equalValue(m.Value, want.Value) {
+				t.Errorf("metric %v: got %+v, want %+v", i, m, want)
+			}
+			if len(want.Properties) > 0 && !reflect.DeepEqual(m.Properties, want.Properties) {
+				t.Errorf("metric %v: properties %v, want %v", want.Name, m.Properties, want.Properties)
+			}
+		}
+	}
+}
+
+func TestRebirthCommand(t *testing.T) {
+	ncmd := Payload{TimeStamp: time.Now(), Metrics: []Metric{{Name: MetricRebirth, DataType: Boolean, Value: true}}}
+	p, err := Unmarshal(ncmd.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Metrics) != 1 || p.Metrics[0].Name != MetricRebirth || p.Metrics[0].Value != true || p.Seq != nil {
+		t.Errorf("got %+v, want rebirth command", p)
+	}
+
+	for _, b := range [][]byte{
+		{0x08},             // truncated varint
+		{0x12, 0x05, 0x0a}, // truncated metric
+		{0x0f},             // invalid wire type
+	} {
+		if _, err = Unmarshal(b); err == nil {
+			t.Errorf("Unmarshal(%x) succeeded, want an error", b)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	if got, want := Topic("energy", NBirth, "s0counter", ""), "spBv1.0/energy/NBIRTH/s0counter"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := Topic("energy", DData, "s0counter", "wallbox"), "spBv1.0/energy/DDATA/s0counter/wallbox"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestReferenceSchema checks the encoding against the protobuf runtime with the Sparkplug B schema
+// (org.eclipse.tahu.protobuf.Payload of sparkplug_b.proto, only the fields used by an edge node).
+func TestReferenceSchema(t *testing.T) {
+	payload, err := referencePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 3, 10, 10, 15, 30, 123000000, time.UTC)
+	p := Payload{TimeStamp: now, Seq: Seq(3), Metrics: []Metric{
+		{Name: "Counter", TimeStamp: now, DataType: Double, Value: 1234.567, Properties: map[string]string{"engUnit": "kWh"}},
+		{Name: MetricBdSeq, DataType: UInt64, Value: uint64(42)},
+		{Name: "Offset", DataType: Int64, Value: int64(-5)},
+		{Name: MetricRebirth, DataType: Boolean, Value: true},
+		{Name: "Tariff", DataType: String, Value: "day"},
+	}}
+
+	// the reference message of the payload
+	want := dynamicpb.NewMessage(payload)
+	fields := payload.Fields()
+	want.Set(fields.ByName("timestamp"), protoreflect.ValueOfUint64(uint64(now.UnixNano()/1e6)))
+	want.Set(fields.ByName("seq"), protoreflect.ValueOfUint64(3))
+	metrics := want.Mutable(fields.ByName("metrics")).List()
+	metric := fields.ByName("metrics").Message()
+	mf := metric.Fields()
+	for _, m := range []struct {
+		name     string
+		datatype DataType
+		field    string
+		value    protoreflect.Value
+	}{
+		{"Counter", Double, "double_value", protoreflect.ValueOfFloat64(1234.567)},
+		{MetricBdSeq, UInt64, "long_value", protoreflect.ValueOfUint64(42)},
+		{"Offset", Int64, "long_value", protoreflect.ValueOfUint64(uint64(1<<64 - 5))},
+		{MetricRebirth, Boolean, "boolean_value", protoreflect.ValueOfBool(true)},
+		{"Tariff", String, "string_value", protoreflect.ValueOfString("day")},
+	} {
+		v := dynamicpb.NewMessage(metric)
+		v.Set(mf.ByName("name"), protoreflect.ValueOfString(m.name))
+		v.Set(mf.ByName("datatype"), protoreflect.ValueOfUint32(uint32(m.datatype)))
+		v.Set(mf.ByName(protoreflect.Name(m.field)), m.value)
+		if m.name == "Counter" {
+			v.Set(mf.ByName("timestamp"), protoreflect.ValueOfUint64(uint64(now.UnixNano()/1e6)))
+			props := v.Mutable(mf.ByName("properties")).Message()
+			pf := props.Descriptor().Fields()
+			props.Mutable(pf.ByName("keys")).List().Append(protoreflect.ValueOfString("engUnit"))
+			pv := props.Mutable(pf.ByName("values")).List().NewElement().Message()
+			pvf := pv.Descriptor().Fields()
+			pv.Set(pvf.ByName("type"), protoreflect.ValueOfUint32(uint32(String)))
+			pv.Set(pvf.ByName("string_value"), protoreflect.ValueOfString("kWh"))
+			props.Mutable(pf.ByName("values")).List().Append(protoreflect.ValueOfMessage(pv))
+		}
+		metrics.Append(protoreflect.ValueOfMessage(v))
+	}
+
+	// our encoding is decoded by the reference schema
+	got := dynamicpb.NewMessage(payload)
+	if err = proto.Unmarshal(p.Marshal(), got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("decoded by the reference schema:\n%v\nwant\n%v", got, want)
+	}
+
+	// the bytes of the reference schema are decoded by Unmarshal
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.TimeStamp.Equal(now) || decoded.Seq == nil || *decoded.Seq != 3 || len(decoded.Metrics) != len(p.Metrics) {
+		t.Fatalf("got %+v, want %+v", decoded, p)
+	}
+	for i, m := range p.Metrics {
+		d := decoded.Metrics[i]
+		if d.Name != m.Name || d.DataType != m.DataType || !d.TimeStamp.Equal(m.TimeStamp) || !equalValue(d.Value, m.Value) || !reflect.DeepEqual(d.Properties, m.Properties) {
+			t.Errorf("metric %v: got %+v, want %+v", i, d, m)
+		}
+	}
+}
+
+// equalValue compares metric values, times are compared by Equal.
+func equalValue(got, want interface{}) bool {
+	if w, ok := want.(time.Time); ok {
+		g, ok := got.(time.Time)
+		return ok && g.Equal(w)
+	}
+	return got == want
+}
+
+// referencePayload returns the message descriptor of the Sparkplug B payload (proto2 syntax like sparkplug_b.proto).
+func referencePayload() (protoreflect.MessageDescriptor, error) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum(), JsonName: proto.String(name)}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	)
+	oneof := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.OneofIndex = proto.Int32(0)
+		return f
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("sparkplug_b.proto"),
+		Package: proto.String("org.eclipse.tahu.protobuf"),
+		Syntax:  proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Payload"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("timestamp", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, ""),
+				field("metrics", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".org.eclipse.tahu.protobuf.Payload.Metric"),
+				field("seq", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, ""),
+				field("uuid", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("body", 5, descriptorpb.FieldDescriptorProto_TYPE_BYTES, optional, ""),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{
+				{
+					Name: proto.String("PropertyValue"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("type", 1, descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, ""),
+						field("is_null", 2, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+						oneof(field("int_value", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, "")),
+						oneof(field("long_value", 4, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, "")),
+						oneof(field("float_value", 5, descriptorpb.FieldDescriptorProto_TYPE_FLOAT, optional, "")),
+						oneof(field("double_value", 6, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, optional, "")),
+						oneof(field("boolean_value", 7, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, "")),
+						oneof(field("string_value", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, "")),
+					},
+					OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("value")}},
+				},
+				{
+					Name: proto.String("PropertySet"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("keys", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+						field("values", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".org.eclipse.tahu.protobuf.Payload.PropertyValue"),
+					},
+				},
+				{
+					Name: proto.String("Metric"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+						field("alias", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, ""),
+						field("timestamp", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, ""),
+						field("datatype", 4, descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, ""),
+						field("is_historical", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+						field("is_transient", 6, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+						field("is_null", 7, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+						field("properties", 9, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".org.eclipse.tahu.protobuf.Payload.PropertySet"),
+						oneof(field("int_value", 10, descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, "")),
+						oneof(field("long_value", 11, descriptorpb.FieldDescriptorProto_TYPE_UINT64, optional, "")),
+						oneof(field("float_value", 12, descriptorpb.FieldDescriptorProto_TYPE_FLOAT, optional, "")),
+						oneof(field("double_value", 13, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, optional, "")),
+						oneof(field("boolean_value", 14, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, "")),
+						oneof(field("string_value", 15, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, "")),
+					},
+					OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("value")}},
+				},
+			},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fd.Messages().ByName("Payload"), nil
+}
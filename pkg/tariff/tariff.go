@@ -0,0 +1,96 @@
+// Package tariff provides time-of-use tariff schedules, e.g. high and low tariff of an electricity contract.
+package tariff
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// Window defines the time window of a tariff.
+// If From is after To, the window lasts over midnight, e.g. 22:00 - 06:00.
+type Window struct {
+	Tariff   string                // name of the tariff
+	Weekdays map[time.Weekday]bool // days of the window, if it's empty, the window is active on every day
+	From     time.Duration         // start of the window, time since midnight
+	To       time.Duration         // end of the window (exclusive), time since midnight
+}
+
+// Schedule defines the tariffs of a contract.
+type Schedule struct {
+	Default       string          // tariff outside of all windows
+	HolidayTariff string          // tariff on holidays, if it's empty, the windows are also used on holidays
+	Holidays      map[string]bool // holidays in format YYYY-MM-DD
+	Windows       []Window        // the first matching window defines the active tariff
+}
+
+// Active returns the active tariff at time t.
+// The part of an overnight window after midnight belongs to the day the window started,
+// so the weekdays and the holidays are checked for the previous day.
+func (s *Schedule) Active(t time.Time) string {
+	h, m, sec := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+
+	for _, w := range s.Windows {
+		day := t
+		switch {
+		case w.From <= w.To:
+			if tod < w.From || tod >= w.To {
+				continue
+			}
+		case tod < w.To:
+			day = t.AddDate(0, 0, -1)
+		case tod < w.From:
+			continue
+		}
+
+		if len(w.Weekdays) > 0 && !w.Weekdays[day.Weekday()] {
+			continue
+		}
+		if s.isHoliday(day) {
+			return s.HolidayTariff
+		}
+		return w.Tariff
+	}
+
+	if s.isHoliday(t) {
+		return s.HolidayTariff
+	}
+	return s.Default
+}
+
+// isHoliday returns true, if the day of t is a holiday and a holiday tariff is defined.
+func (s *Schedule) isHoliday(t time.Time) bool {
+	return s.HolidayTariff != "" && s.Holidays[t.Format(dateFormat)]
+}
+
+// LoadHolidays reads the holidays of a file, one date per line in format YYYY-MM-DD.
+// Empty lines and lines starting with # are ignored, text after the date is a comment.
+func LoadHolidays(fileName string) (map[string]bool, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := map[string]bool{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		d := strings.Fields(l)[0]
+		if _, err = time.Parse(dateFormat, d); err != nil {
+			return nil, fmt.Errorf("%v line %v: invalid date %q", fileName, n, d)
+		}
+		h[d] = true
+	}
+
+	return h, s.Err()
+}
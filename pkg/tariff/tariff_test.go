@@ -0,0 +1,92 @@
+package tariff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActive(t *testing.T) {
+	weekdays := map[time.Weekday]bool{time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Friday: true}
+	s := &Schedule{
+		Default:       "low",
+		HolidayTariff: "holiday",
+		// 2026-03-10 is a tuesday
+		Holidays: map[string]bool{"2026-03-10": true},
+		Windows: []Window{
+			{Tariff: "high", Weekdays: weekdays, From: 6 * time.Hour, To: 22 * time.Hour},
+			{Tariff: "night", Weekdays: weekdays, From: 22 * time.Hour, To: 6 * time.Hour},
+		},
+	}
+
+	for _, tc := range []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 9, 5, 59, 0, 0, time.UTC), "low"},   // monday morning, the window started on sunday
+		{time.Date(2026, 3, 9, 6, 0, 0, 0, time.UTC), "high"},   // monday
+		{time.Date(2026, 3, 9, 21, 59, 0, 0, time.UTC), "high"}, // monday
+		{time.Date(2026, 3, 9, 22, 0, 0, 0, time.UTC), "night"}, // monday evening
+		{time.Date(2026, 3, 10, 2, 0, 0, 0, time.UTC), "night"}, // holiday morning, the window started on monday
+		{time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), "holiday"},
+		{time.Date(2026, 3, 10, 23, 0, 0, 0, time.UTC), "holiday"}, // the window starts on the holiday
+		{time.Date(2026, 3, 11, 2, 0, 0, 0, time.UTC), "holiday"},  // wednesday morning, the window started on the holiday
+		{time.Date(2026, 3, 11, 6, 0, 0, 0, time.UTC), "high"},
+		{time.Date(2026, 3, 13, 23, 0, 0, 0, time.UTC), "night"}, // friday evening
+		{time.Date(2026, 3, 14, 2, 0, 0, 0, time.UTC), "night"},  // saturday morning, the window started on friday
+		{time.Date(2026, 3, 14, 23, 0, 0, 0, time.UTC), "low"},   // saturday evening
+		{time.Date(2026, 3, 16, 2, 0, 0, 0, time.UTC), "low"},    // monday morning, the window started on sunday
+	} {
+		if got := s.Active(tc.t); got != tc.want {
+			t.Errorf("%v %v: got %q, want %q", tc.t.Weekday(), tc.t, got, tc.want)
+		}
+	}
+
+	// without a holiday tariff, the windows are used on holidays
+	s.HolidayTariff = ""
+	if got := s.Active(time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)); got != "high" {
+		t.Errorf("holiday without holiday tariff: got %q, want %q", got, "high")
+	}
+}
+
+func TestLoadHolidays(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		text  string
+		want  []string
+		valid bool
+	}{
+		{"empty", "", nil, true},
+		{"dates", "2026-01-01\n2026-12-25\n", []string{"2026-01-01", "2026-12-25"}, true},
+		{"comments", "# holidays\n\n  2026-01-06 epiphany\n", []string{"2026-01-06"}, true},
+		{"invalid date", "2026-01-01\n2026-02-30\n", nil, false},
+		{"invalid format", "01.01.2026\n", nil, false},
+	} {
+		file := filepath.Join(t.TempDir(), "holidays.txt")
+		if err := os.WriteFile(file, []byte(tc.text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := LoadHolidays(file)
+		if (err == nil) != tc.valid {
+			t.Errorf("%v: got error %v, want valid %v", tc.name, err, tc.valid)
+			continue
+		}
+		if !tc.valid {
+			continue
+		}
+		if len(h) != len(tc.want) {
+			t.Errorf("%v: got %v holidays, want %v", tc.name, len(h), len(tc.want))
+		}
+		for _, d := range tc.want {
+			if !h[d] {
+				t.Errorf("%v: %v isn't a holiday", tc.name, d)
+			}
+		}
+	}
+
+	if _, err := LoadHolidays(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
@@ -0,0 +1,182 @@
+// Package webhook delivers the events as http post requests to the webhook endpoints,
+// failed requests are retried with an exponential backoff and the body is signed with a hmac signature.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/womat/debug"
+)
+
+// headers of the webhook requests
+const (
+	// SignatureHeader contains the hmac sha256 signature of the body, e.g. sha256=5d41402abc4b...
+	SignatureHeader = "X-S0counter-Signature"
+	// EventHeader contains the type of the event, e.g. alarm
+	EventHeader = "X-S0counter-Event"
+)
+
+// queueSize is the number of requests buffered per endpoint, further requests are dropped
+const queueSize = 256
+
+// Options defines the properties of a webhook endpoint.
+type Options struct {
+	URL         string            // url of the endpoint
+	Secret      string            // secret of the hmac signature, if it's empty, the body isn't signed
+	ContentType string            // content type of the body
+	Headers     map[string]string // additional headers, e.g. Authorization
+	Timeout     time.Duration     // timeout of a request
+	Retries     int               // number of retries of a failed request
+	Backoff     time.Duration     // wait time before the first retry, it's doubled with each retry
+	MaxBackoff  time.Duration     // maximum wait time between two retries
+}
+
+// Request is an event, which is posted to the endpoint.
+type Request struct {
+	Event string // type of the event
+	Body  []byte // body of the request
+}
+
+// Endpoint posts the requests in order of their arrival to the url of the endpoint.
+type Endpoint struct {
+	name    string
+	options Options
+	client  *http.Client
+	c       chan Request
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// StatusError is returned, if the endpoint responds with a status code other than 2xx.
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %v %v", e.Code, http.StatusText(e.Code))
+}
+
+// temporary returns true, if the request can succeed with a retry, e.g. on 503 Service Unavailable.
+func (e StatusError) temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests
+}
+
+// New returns an endpoint and starts the delivery of the queued requests.
+func New(name string, o Options) *Endpoint {
+	e := &Endpoint{
+		name:    name,
+		options: o,
+		client:  &http.Client{Timeout: o.Timeout},
+		c:       make(chan Request, queueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go e.service()
+	return e
+}
+
+// Send queues the request, if the queue is full, the request is dropped.
+func (e *Endpoint) Send(r Request) {
+	select {
+	case e.c <- r:
+	default:
+		debug.WarningLog.Printf("webhook %v queue is full, drop %v event", e.name, r.Event)
+	}
+}
+
+// Close stops the delivery, the queued requests and a running retry are dropped.
+func (e *Endpoint) Close() {
+	close(e.stop)
+	<-e.done
+
+	if n := len(e.c); n > 0 {
+		debug.WarningLog.Printf("webhook %v is closed, drop %v queued events", e.name, n)
+	}
+}
+
+// Post posts the request once, without retries.
+func (e *Endpoint) Post(r Request) error {
+	req, err := http.NewRequest(http.MethodPost, e.options.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", e.options.ContentType)
+	req.Header.Set(EventHeader, r.Event)
+	for k, v := range e.options.Headers {
+		req.Header.Set(k, v)
+	}
+	if e.options.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(e.options.Secret, r.Body))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return StatusError{Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// Sign returns the hmac sha256 signature of the body in the format sha256=<hex>.
+func Sign(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// service posts the queued requests until the endpoint is closed.
+func (e *Endpoint) service() {
+	defer close(e.done)
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case r := <-e.c:
+			if !e.deliver(r) {
+				return
+			}
+		}
+	}
+}
+
+// deliver posts the request and retries it with an exponential backoff, if the request failed temporarily.
+// It returns false, if the endpoint has been closed during a retry.
+func (e *Endpoint) deliver(r Request) bool {
+	wait := e.options.Backoff
+	for retry := 0; ; retry++ {
+		err := e.Post(r)
+		if err == nil {
+			debug.DebugLog.Printf("webhook %v: posted %v event", e.name, r.Event)
+			return true
+		}
+
+		if s, ok := err.(StatusError); (ok && !s.temporary()) || retry >= e.options.Retries {
+			debug.ErrorLog.Printf("webhook %v: can't post %v event, drop it: %v", e.name, r.Event, err)
+			return true
+		}
+
+		debug.WarningLog.Printf("webhook %v: can't post %v event, retry in %v: %v", e.name, r.Event, wait, err)
+		select {
+		case <-e.stop:
+			return false
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > e.options.MaxBackoff {
+			wait = e.options.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received is a request, which has been received by the test server.
+type received struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// newServer starts a test server, which responds to the n-th request (starting at 0) with status(n).
+// The received requests are sent to the returned channel.
+func newServer(t *testing.T, status func(n int) int) (*httptest.Server, <-chan received) {
+	t.Helper()
+
+	var mu sync.Mutex
+	n := 0
+	c := make(chan received, 100)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		code := status(n)
+		n++
+		mu.Unlock()
+
+		c <- received{at: time.Now(), header: r.Header.Clone(), body: body}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s, c
+}
+
+// expect waits for the next request.
+func expect(t *testing.T, c <-chan received) received {
+	t.Helper()
+
+	select {
+	case r := <-c:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+	}
+	return received{}
+}
+
+// expectNone checks, that no further request is received within d.
+func expectNone(t *testing.T, c <-chan received, d time.Duration) {
+	t.Helper()
+
+	select {
+	case <-c:
+		t.Error("unexpected request received")
+	case <-time.After(d):
+	}
+}
+
+func TestRetryTemporaryStatus(t *testing.T) {
+	for _, code := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusRequestTimeout, http.StatusTooManyRequests} {
+		code := code
+		s, c := newServer(t, func(n int) int {
+			if n < 2 {
+				return code
+			}
+			return http.StatusOK
+		})
+
+		e := New("test", Options{URL: s.URL, Timeout: time.Second, Retries: 5, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+		e.Send(Request{Event: "alarm", Body: []byte("{}")})
+
+		// two failed requests and the successful retry
+		for i := 0; i < 3; i++ {
+			expect(t, c)
+		}
+		expectNone(t, c, 50*time.Millisecond)
+		e.Close()
+	}
+}
+
+func TestNoRetryClientError(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity} {
+		s, c := newServer(t, func(int) int { return code })
+
+		e := New("test", Options{URL: s.URL, Timeout: time.Second, Retries: 5, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+		e.Send(Request{Event: "alarm", Body: []byte("{}")})
+
+		expect(t, c)
+		expectNone(t, c, 50*time.Millisecond)
+		e.Close()
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s, c := newServer(t, func(int) int { return http.StatusServiceUnavailable })
+
+	backoff, maxBackoff := 50*time.Millisecond, 120*time.Millisecond
+	e := New("test", Options{URL: s.URL, Timeout: time.Second, Retries: 4, Backoff: backoff, MaxBackoff: maxBackoff})
+	defer e.Close()
+	e.Send(Request{Event: "alarm", Body: []byte("{}")})
+
+	// the wait time is doubled with each retry up to the maximum backoff
+	waits := []time.Duration{backoff, 2 * backoff, maxBackoff, maxBackoff}
+	last := expect(t, c).at
+	for i, wait := range waits {
+		at := expect(t, c).at
+		if d := at.Sub(last); d < wait || d > wait+70*time.Millisecond {
+			t.Errorf("retry %v after %v, want %v", i+1, d, wait)
+		}
+		last = at
+	}
+
+	// the request is dropped after the last retry
+	expectNone(t, c, 2*maxBackoff)
+}
+
+func TestSignature(t *testing.T) {
+	// well known hmac sha256 test vector
+	if got, want := Sign("key", []byte("The quick brown fox jumps over the lazy dog")),
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; got != want {
+		t.Errorf("Sign = %v, want %v", got, want)
+	}
+
+	s, c := newServer(t, func(int) int { return http.StatusOK })
+
+	e := New("test", Options{URL: s.URL, Secret: "mysecret", ContentType: "application/json", Headers: map[string]string{"Authorization": "Bearer token"}, Timeout: time.Second})
+	defer e.Close()
+	body := []byte(`{"Event":"alarm"}`)
+	e.Send(Request{Event: "alarm", Body: body})
+
+	r := expect(t, c)
+	if string(r.body) != string(body) {
+		t.Errorf("body %q, want %q", r.body, body)
+	}
+	if got, want := r.header.Get(SignatureHeader), Sign("mysecret", body); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	if got := r.header.Get(EventHeader); got != "alarm" {
+		t.Errorf("event header %q, want alarm", got)
+	}
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type %q, want application/json", got)
+	}
+	if got := r.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("authorization header %q, want Bearer token", got)
+	}
+
+	// without secret, the body isn't signed
+	u := New("unsigned", Options{URL: s.URL, Timeout: time.Second})
+	defer u.Close()
+	u.Send(Request{Event: "alarm", Body: body})
+	if got := expect(t, c).header.Get(SignatureHeader); got != "" {
+		t.Errorf("signature %q of an endpoint without secret", got)
+	}
+}
+
+func TestCloseAbortsRetry(t *testing.T) {
+	s, c := newServer(t, func(int) int { return http.StatusServiceUnavailable })
+
+	e := New("test", Options{URL: s.URL, Timeout: time.Second, Retries: 5, Backoff: time.Hour, MaxBackoff: time.Hour})
+	e.Send(Request{Event: "alarm", Body: []byte("{}")})
+	expect(t, c)
+
+	done := make(chan struct{})
+	go func() {
+		e.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close doesn't abort the retry")
+	}
+	expectNone(t, c, 50*time.Millisecond)
+}